@@ -22,28 +22,56 @@ type Opts struct {
 
 	// lease
 	Lease struct {
-		Enabled bool   `long:"lease.enable"  env:"LEASE_ENABLE"  description:"Enable lease (leader election; enabled by default in docker images)"`
-		Name    string `long:"lease.name"    env:"LEASE_NAME"    description:"Name of lease lock"     default:"azure-msi-operator-leader"`
+		Enabled       bool          `long:"lease.enable"         env:"LEASE_ENABLE"          description:"Enable lease (leader election; enabled by default in docker images)"`
+		Name          string        `long:"lease.name"           env:"LEASE_NAME"            description:"Name of lease lock"     default:"azure-msi-operator-leader"`
+		LeaseDuration time.Duration `long:"lease.duration"       env:"LEASE_DURATION"        description:"Duration non-leaders will wait before trying to aquire the lease (time.duration)"  default:"15s"`
+		RenewDeadline time.Duration `long:"lease.renewdeadline"  env:"LEASE_RENEW_DEADLINE"  description:"Duration the leader will retry refreshing leadership before giving up (time.duration)"  default:"10s"`
+		RetryPeriod   time.Duration `long:"lease.retryperiod"    env:"LEASE_RETRY_PERIOD"    description:"Duration between leader election retries (time.duration)"  default:"2s"`
 	}
 
 	// Sync settings
 	Sync struct {
-		Interval time.Duration `long:"sync.interval" env:"SYNC_INTERVAL"  description:"Sync interval (time.duration)"  default:"1h"`
+		Interval time.Duration `long:"sync.interval" env:"SYNC_INTERVAL"  description:"Sync interval for full Kubernetes reconciliation (time.duration)"  default:"1h"`
 		Watch    bool          `long:"sync.watch"    env:"SYNC_WATCH"     description:"Sync using namespace watch"`
 		LockTime time.Duration `long:"sync.locktime" env:"SYNC_LOCKTIME"  description:"Lock time until next sync (time.duration)" default:"5m"`
+
+		DiscoveryInterval time.Duration `long:"sync.discovery.interval"  env:"SYNC_DISCOVERY_INTERVAL"  description:"Interval for Azure MSI discovery, namespaces of changed MSIs are reconciled immediately (time.duration; 0 = sync interval)"`
+		Jitter            float64       `long:"sync.jitter"              env:"SYNC_JITTER"              description:"Random delay added to sync intervals (fraction of interval)" default:"0.1"`
+		BackoffInitial    time.Duration `long:"sync.backoff.initial"     env:"SYNC_BACKOFF_INITIAL"     description:"Retry delay after failed sync, doubled on each failure (time.duration)" default:"30s"`
+		BackoffMax        time.Duration `long:"sync.backoff.max"         env:"SYNC_BACKOFF_MAX"         description:"Maximum retry delay after failed sync, capped at sync interval (time.duration)" default:"15m"`
+
+		LivenessDeadline time.Duration `long:"sync.liveness.deadline" env:"SYNC_LIVENESS_DEADLINE"  description:"Liveness fails if no sync run (successful or failed) finished within this duration (time.duration; 0 = 3x sync interval, negative = disabled)"`
 	}
 
 	// azure settings
 	Azure struct {
 		Environment  string   `long:"azure.environment"   env:"AZURE_ENVIRONMENT"                    description:"Azure environment name" default:"AZUREPUBLICCLOUD"`
 		Subscription []string `long:"azure.subscription"  env:"AZURE_SUBSCRIPTION_ID" env-delim:" "  description:"Azure subscription ID"`
+
+		SystemAssigned     []string `long:"azure.systemassigned.resourcetype"  env:"AZURE_SYSTEMASSIGNED_RESOURCETYPE"  env-delim:" "  description:"Discover system-assigned identities of Azure resources of these types (eg. Microsoft.Compute/virtualMachineScaleSets, Microsoft.Web/sites, Microsoft.ContainerInstance/containerGroups; API, inventory and metrics only unless --azure.systemassigned.sync is set)"`
+		SystemAssignedSync bool     `long:"azure.systemassigned.sync"  env:"AZURE_SYSTEMASSIGNED_SYNC"  description:"Sync system-assigned identities as AzureIdentity like user-assigned identities (aad-pod-identity MIC can't assign them to nodes, see README)"`
+
+		RoleAssignments struct {
+			Enabled       bool     `long:"azure.roleassignments"                env:"AZURE_ROLEASSIGNMENTS"                               description:"Discover Azure RBAC role assignments of Azure MSIs (AzureIdentity annotation, API and metrics)"`
+			HighPrivilege []string `long:"azure.roleassignments.highprivilege"  env:"AZURE_ROLEASSIGNMENTS_HIGHPRIVILEGE"  env-delim:" "  description:"Roles which are flagged as high privilege if assigned at subscription (or higher) scope" default:"Owner" default:"Contributor"` //nolint:golint,staticcheck
+		}
 	}
 
 	// kubernetes settings
 	Kubernetes struct {
 		Config          string   `long:"kubeconfig" env:"KUBECONFIG"                                                 description:"Kuberentes config path (should be empty if in-cluster)"`
+		ClusterName     string   `long:"kubernetes.cluster.name" env:"KUBERNETES_CLUSTER_NAME"                       description:"Name of the cluster the operator is running in (used for cluster selection of Azure MSIs)" default:"local"`
 		LabelFormat     string   `long:"kubernetes.label.format" env:"KUBERNETES_LABEL_FORMAT"                       description:"Kubernetes label format (sprintf, if empty, labels are not set)" default:"msi.azure.k8s.io/%s"`
-		NamespaceIgnore []string `long:"kubernetes.namespace.ignore" env:"KUBERNETES_NAMESPACE_IGNORE" env-delim:" " description:"Do not not maintain these namespaces" default:"kube-system" default:"kube-public" default:"default" default:"gatekeeper-system" default:"istio-system"` //nolint:golint,staticcheck
+		NamespaceIgnore []string `long:"kubernetes.namespace.ignore" env:"KUBERNETES_NAMESPACE_IGNORE" env-delim:" " description:"Do not not maintain these namespaces (glob or /regexp/)" default:"kube-system" default:"kube-public" default:"default" default:"gatekeeper-system" default:"istio-system"` //nolint:golint,staticcheck
+		NamespaceAllow  []string `long:"kubernetes.namespace.allow"  env:"KUBERNETES_NAMESPACE_ALLOW"  env-delim:" " description:"Only maintain these namespaces (glob or /regexp/, if empty all non-ignored namespaces are maintained)"`
+
+		QPS              float32 `long:"kubernetes.qps"                env:"KUBERNETES_QPS"                description:"Max queries per second to Kubernetes API (client side rate limit)" default:"5"`
+		Burst            int     `long:"kubernetes.burst"              env:"KUBERNETES_BURST"              description:"Max burst of queries to Kubernetes API (client side rate limit)" default:"10"`
+		WriteConcurrency int     `long:"kubernetes.write.concurrency"  env:"KUBERNETES_WRITE_CONCURRENCY"  description:"Number of Azure MSIs upserted concurrently" default:"4"`
+
+		NamespaceMissing           string            `long:"kubernetes.namespace.missing"           env:"KUBERNETES_NAMESPACE_MISSING"                           description:"Behaviour if target namespace doesn't exist (skip, error, create)" choice:"skip" choice:"error" choice:"create" default:"error"`
+		NamespaceCreateLabels      map[string]string `long:"kubernetes.namespace.create.label"      env:"KUBERNETES_NAMESPACE_CREATE_LABEL"      env-delim:" "  description:"Labels for created namespaces (key:value)"`
+		NamespaceCreateAnnotations map[string]string `long:"kubernetes.namespace.create.annotation" env:"KUBERNETES_NAMESPACE_CREATE_ANNOTATION" env-delim:" "  description:"Annotations for created namespaces (key:value)"`
 	}
 
 	// AzureIdentity
@@ -52,9 +80,13 @@ type Opts struct {
 		Namespaced           bool   `long:"azureidentity.namespaced"             env:"AZUREIDENTITY_NAMESPACED"             description:"Set aadpodidentity.k8s.io/Behavior=namespaced annotation for AzureIdenity resources"`
 		TemplateNamespace    string `long:"azureidentity.template.namespace"     env:"AZUREIDENTITY_TEMPLATE_NAMESPACE"     description:"Golang template for Kubernetes namespace" default:"{{index .Tags \"k8snamespace\"}}"`
 		TemplateResourceName string `long:"azureidentity.template.resourcename"  env:"AZUREIDENTITY_TEMPLATE_RESOURCENAME"  description:"Golang template for Kubernetes resource name" default:"{{ .Name }}-{{ .ClientId }}"`
+		TemplateCluster      string `long:"azureidentity.template.cluster"       env:"AZUREIDENTITY_TEMPLATE_CLUSTER"       description:"Golang template for cluster filter (comma separated cluster names or globs, all clusters if empty)" default:"{{index .Tags \"k8scluster\"}}"`
 
 		Binding struct {
-			Sync bool `long:"azureidentity.binding.sync"  env:"AZUREIDENTITY_BINDING_SYNC"  description:"Sync AzureIdentity to AzureIdentityBinding using lookup label"`
+			Sync             bool   `long:"azureidentity.binding.sync"               env:"AZUREIDENTITY_BINDING_SYNC"               description:"Sync AzureIdentity to AzureIdentityBinding using lookup label"`
+			Lookup           string `long:"azureidentity.binding.lookup"             env:"AZUREIDENTITY_BINDING_LOOKUP"             description:"Lookup strategy for AzureIdentityBinding sync (labels, annotation, alias)" choice:"labels" choice:"annotation" choice:"alias" default:"labels"`
+			Generate         bool   `long:"azureidentity.binding.generate"           env:"AZUREIDENTITY_BINDING_GENERATE"           description:"Generate AzureIdentityBinding for each AzureIdentity (if selector template is not empty)"`
+			TemplateSelector string `long:"azureidentity.binding.template.selector"  env:"AZUREIDENTITY_BINDING_TEMPLATE_SELECTOR"  description:"Golang template for AzureIdentityBinding selector" default:"{{index .Tags \"k8sselector\"}}"`
 		}
 
 		Expiry struct {
@@ -65,12 +97,90 @@ type Opts struct {
 		}
 	}
 
+	// multi-cluster settings
+	MultiCluster struct {
+		Enabled        bool          `long:"multicluster.enable"           env:"MULTICLUSTER_ENABLE"           description:"Reconcile Azure MSIs to additional clusters (kubeconfig secrets in operator namespace)"`
+		SecretSelector string        `long:"multicluster.secret.selector"  env:"MULTICLUSTER_SECRET_SELECTOR"  description:"Label selector for kubeconfig secrets of additional clusters" default:"msi.azure.k8s.io/cluster"`
+		LocalDisable   bool          `long:"multicluster.local.disable"    env:"MULTICLUSTER_LOCAL_DISABLE"    description:"Don't reconcile Azure MSIs to the cluster the operator is running in"`
+		Timeout        time.Duration `long:"multicluster.timeout"          env:"MULTICLUSTER_TIMEOUT"          description:"Timeout for Kubernetes API requests to additional clusters (time.duration)" default:"30s"`
+	}
+
+	// policy for Azure MSIs and namespaces
+	Policy struct {
+		File string `long:"policy.file"  env:"POLICY_FILE"  description:"Path of policy file (YAML) restricting which Azure MSIs may be synced to which namespaces (disabled if empty)"`
+	}
+
+	// sync run history
+	History struct {
+		Size      int    `long:"history.size"       env:"HISTORY_SIZE"       description:"Number of sync runs kept in history (/api/v1/runs)" default:"20"`
+		ConfigMap string `long:"history.configmap"  env:"HISTORY_CONFIGMAP"  description:"Name of ConfigMap in operator namespace for sync run summary (disabled if empty, adjust resourceNames of the RBAC Role if changed)" default:"azure-msi-operator-status"`
+	}
+
+	// audit log of changes made by the operator
+	Audit struct {
+		Output         string        `long:"audit.output"           env:"AUDIT_OUTPUT"           description:"Output of audit log for changes made by the operator (JSON lines; disabled if empty)" choice:"" choice:"stdout" choice:"file" choice:"webhook"`
+		Tag            string        `long:"audit.tag"              env:"AUDIT_TAG"              description:"Tag of audit records (eg. for filtering stdout by log collector)" default:"azure-msi-operator-audit"`
+		Actor          string        `long:"audit.actor"            env:"AUDIT_ACTOR"            description:"Actor identity of audit records (defaults to --webhook.serviceaccount)"`
+		File           string        `long:"audit.file"             env:"AUDIT_FILE"             description:"Path of audit log file (output file)"`
+		WebhookUrl     string        `long:"audit.webhook.url"      env:"AUDIT_WEBHOOK_URL"      description:"URL for audit records (output webhook, records are sent via POST)"`
+		WebhookToken   string        `long:"audit.webhook.token"    env:"AUDIT_WEBHOOK_TOKEN"    description:"Bearer token for audit webhook" json:"-"`
+		WebhookTimeout time.Duration `long:"audit.webhook.timeout"  env:"AUDIT_WEBHOOK_TIMEOUT"  description:"Timeout for audit webhook requests (time.duration)" default:"5s"`
+	}
+
+	// notifications
+	Notification struct {
+		Targets         []string      `long:"notification.target"            env:"NOTIFICATION_TARGET"            env-delim:" "  description:"Notification target (type=url, types: webhook, slack, teams)" json:"-"`
+		Events          []string      `long:"notification.event"             env:"NOTIFICATION_EVENT"             env-delim:" "  description:"Events which are notified" choice:"failed" choice:"granted" default:"failed" default:"granted"` //nolint:golint,staticcheck
+		TemplateFailed  string        `long:"notification.template.failed"   env:"NOTIFICATION_TEMPLATE_FAILED"                  description:"Golang template for failed sync notifications" default:"Azure MSI {{ .AzureResourceName }} failed to sync to {{ .Kind }} \"{{ .Namespace }}/{{ .Name }}\": {{ .Error }}"`
+		TemplateGranted string        `long:"notification.template.granted"  env:"NOTIFICATION_TEMPLATE_GRANTED"                 description:"Golang template for notifications of identities granted into a namespace" default:"Azure MSI {{ .AzureResourceName }} was granted into namespace \"{{ .Namespace }}\" as {{ .Kind }} \"{{ .Name }}\""`
+		RateLimit       int           `long:"notification.ratelimit"         env:"NOTIFICATION_RATELIMIT"                        description:"Max notifications per minute (additional notifications are dropped)" default:"10"`
+		RepeatInterval  time.Duration `long:"notification.repeat"            env:"NOTIFICATION_REPEAT"                           description:"Minimum interval for repeated notifications of the same event and resource (time.duration)" default:"1h"`
+		Timeout         time.Duration `long:"notification.timeout"           env:"NOTIFICATION_TIMEOUT"                          description:"Timeout for notification requests (time.duration)" default:"10s"`
+	}
+
+	// OpenTelemetry tracing
+	Tracing struct {
+		Enabled     bool    `long:"tracing.enable"        env:"TRACING_ENABLE"        description:"Enable OpenTelemetry tracing of sync runs (OTLP/HTTP export)"`
+		Endpoint    string  `long:"tracing.endpoint"      env:"TRACING_ENDPOINT"      description:"OTLP/HTTP endpoint for trace export (host:port)" default:"localhost:4318"`
+		Insecure    bool    `long:"tracing.insecure"      env:"TRACING_INSECURE"      description:"Export traces via plain HTTP (without TLS)"`
+		SampleRatio float64 `long:"tracing.sample-ratio"  env:"TRACING_SAMPLE_RATIO"  description:"Ratio of sampled sync runs (0..1)" default:"1"`
+		ServiceName string  `long:"tracing.service-name"  env:"TRACING_SERVICE_NAME"  description:"Service name of exported traces" default:"azure-msi-operator"`
+	}
+
+	// shutdown settings
+	Shutdown struct {
+		Timeout time.Duration `long:"shutdown.timeout"  env:"SHUTDOWN_TIMEOUT"  description:"Timeout for running syncs on shutdown (time.duration)" default:"30s"`
+	}
+
+	// admission webhook settings
+	Webhook struct {
+		Validating       bool     `long:"webhook.validating"         env:"WEBHOOK_VALIDATING"                       description:"Enable validating admission webhook for AzureIdentity and AzureIdentityBinding (/webhook/validate, requires TLS)"`
+		ServiceAccount   string   `long:"webhook.serviceaccount"     env:"WEBHOOK_SERVICEACCOUNT"                   description:"Username of operator ServiceAccount allowed to manage AzureIdentity resources" default:"system:serviceaccount:kube-system:azure-msi-operator"`
+		ExemptUsers      []string `long:"webhook.exempt.user"        env:"WEBHOOK_EXEMPT_USER"       env-delim:" "  description:"Users which are exempt from validation"`
+		ExemptGroups     []string `long:"webhook.exempt.group"       env:"WEBHOOK_EXEMPT_GROUP"      env-delim:" "  description:"Groups which are exempt from validation" default:"system:masters"`
+		ExemptNamespaces []string `long:"webhook.exempt.namespace"   env:"WEBHOOK_EXEMPT_NAMESPACE"  env-delim:" "  description:"Namespaces which are exempt from validation (glob or /regexp/)"`
+
+		Mutating bool `long:"webhook.mutating"  env:"WEBHOOK_MUTATING"  description:"Enable mutating admission webhook for Pods referencing an Azure MSI by label (/webhook/mutate, requires TLS; injects aadpodidbinding label only, no ServiceAccount injection)"`
+	}
+
+	// Event Grid settings
+	EventGrid struct {
+		Enabled bool   `long:"eventgrid.enable"  env:"EVENTGRID_ENABLE"  description:"Enable Event Grid receiver (CloudEvents schema) for Azure MSI changes (/api/v1/eventgrid)"`
+		Token   string `long:"eventgrid.token"   env:"EVENTGRID_TOKEN"   description:"Token required as query parameter for Event Grid receiver (?token=xxx, required if Event Grid receiver is enabled)" json:"-"`
+	}
+
 	// server settings
 	Server struct {
 		// general options
 		Bind         string        `long:"server.bind"              env:"SERVER_BIND"           description:"Server address"        default:":8080"`
 		ReadTimeout  time.Duration `long:"server.timeout.read"      env:"SERVER_TIMEOUT_READ"   description:"Server read timeout"   default:"5s"`
 		WriteTimeout time.Duration `long:"server.timeout.write"     env:"SERVER_TIMEOUT_WRITE"  description:"Server write timeout"  default:"10s"`
+		TlsBind      string        `long:"server.tls.bind"          env:"SERVER_TLS_BIND"       description:"Server address of TLS listener for admission webhooks (/webhook/...)" default:":8443"`
+		TlsCert      string        `long:"server.tls.cert"          env:"SERVER_TLS_CERT"       description:"Path to TLS certificate for admission webhooks (required for admission webhooks)"`
+		TlsKey       string        `long:"server.tls.key"           env:"SERVER_TLS_KEY"        description:"Path to TLS key"`
+		Api          bool          `long:"server.api"               env:"SERVER_API"            description:"Enable read-only HTTP API for discovered Azure MSIs (/api/v1/...)"`
+		ApiToken     string        `long:"server.api.token"         env:"SERVER_API_TOKEN"      description:"Bearer token required for role assignments in API responses (roleAssignments, highPrivilege; omitted if empty)" json:"-"`
+		SyncToken    string        `long:"server.sync.token"        env:"SERVER_SYNC_TOKEN"     description:"Bearer token for manual sync trigger (/api/v1/sync, disabled if empty)" json:"-"`
 	}
 }
 
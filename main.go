@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -36,16 +39,36 @@ func main() {
 	logger.Infof("starting azure-msi-operator v%s (%s; %s; by %v)", gitTag, gitCommit, runtime.Version(), Author)
 	logger.Info(string(Opts.GetJson()))
 
+	// root context, cancelled on shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	msiOperator := operator.MsiOperator{
 		Conf:      Opts,
 		UserAgent: "azure-msi-operator/" + gitTag,
 		Logger:    logger,
 	}
-	msiOperator.Init()
+	msiOperator.Init(ctx)
 	msiOperator.Start(Opts.Sync.Interval)
 
 	logger.Infof("starting http server on %s", Opts.Server.Bind)
-	startHttpServer()
+	servers := []*http.Server{startHttpServer(&msiOperator)}
+	if webhookSrv := startWebhookServer(&msiOperator); webhookSrv != nil {
+		servers = append(servers, webhookSrv)
+	}
+
+	<-ctx.Done()
+	logger.Info("received shutdown signal, starting graceful shutdown")
+	msiOperator.Shutdown(Opts.Shutdown.Timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), Opts.Server.WriteTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err)
+		}
+	}
+	logger.Info("shutdown finished")
 }
 
 // init argparser and parse/validate arguments
@@ -68,19 +91,38 @@ func initArgparser() {
 }
 
 // start and handle prometheus handler
-func startHttpServer() {
+func startHttpServer(msiOperator *operator.MsiOperator) *http.Server {
 	mux := http.NewServeMux()
 
 	// healthz
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		if _, err := fmt.Fprint(w, "Ok"); err != nil {
+		ok, msg := msiOperator.CheckLiveness()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := fmt.Fprint(w, msg); err != nil {
 			logger.Error(err)
 		}
 	})
 
 	// readyz
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-		if _, err := fmt.Fprint(w, "Ok"); err != nil {
+		ok, msg := msiOperator.CheckReadiness()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := fmt.Fprint(w, msg); err != nil {
+			logger.Error(err)
+		}
+	})
+
+	// leaderz
+	mux.HandleFunc("/leaderz", func(w http.ResponseWriter, r *http.Request) {
+		ok, msg := msiOperator.CheckLeadership()
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := fmt.Fprint(w, msg); err != nil {
 			logger.Error(err)
 		}
 	})
@@ -88,11 +130,71 @@ func startHttpServer() {
 	// prom metrics
 	mux.Handle("/metrics", azuretracing.RegisterAzureMetricAutoClean(promhttp.Handler()))
 
+	// api
+	if Opts.Server.Api {
+		mux.HandleFunc(operator.ApiPathIdentities, msiOperator.HandleApiIdentities)
+		mux.HandleFunc(operator.ApiPathNamespaces, msiOperator.HandleApiNamespace)
+		mux.HandleFunc(operator.ApiPathRuns, msiOperator.HandleApiRuns)
+	}
+
+	// manual sync trigger
+	if Opts.Server.SyncToken != "" {
+		mux.HandleFunc(operator.ApiPathSync, msiOperator.HandleApiSync)
+	}
+
+	// Event Grid receiver
+	if Opts.EventGrid.Enabled {
+		mux.HandleFunc(operator.ApiPathEventGrid, msiOperator.HandleEventGrid)
+	}
+
 	srv := &http.Server{
 		Addr:         Opts.Server.Bind,
 		Handler:      mux,
 		ReadTimeout:  Opts.Server.ReadTimeout,
 		WriteTimeout: Opts.Server.WriteTimeout,
 	}
-	logger.Fatal(srv.ListenAndServe())
+
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(err)
+		}
+	}()
+
+	return srv
+}
+
+// start TLS server for admission webhooks (separate listener, health checks and metrics stay on plain HTTP)
+func startWebhookServer(msiOperator *operator.MsiOperator) *http.Server {
+	if !Opts.Webhook.Validating && !Opts.Webhook.Mutating {
+		return nil
+	}
+
+	if Opts.Server.TlsCert == "" || Opts.Server.TlsKey == "" {
+		logger.Fatal("admission webhooks require TLS (--server.tls.cert and --server.tls.key)")
+	}
+
+	mux := http.NewServeMux()
+	if Opts.Webhook.Validating {
+		mux.HandleFunc("/webhook/validate", msiOperator.HandleValidatingWebhook)
+	}
+
+	if Opts.Webhook.Mutating {
+		mux.HandleFunc("/webhook/mutate", msiOperator.HandleMutatingWebhook)
+	}
+
+	srv := &http.Server{
+		Addr:         Opts.Server.TlsBind,
+		Handler:      mux,
+		ReadTimeout:  Opts.Server.ReadTimeout,
+		WriteTimeout: Opts.Server.WriteTimeout,
+	}
+
+	logger.Infof("starting admission webhook server (TLS) on %s", Opts.Server.TlsBind)
+	go func() {
+		if err := srv.ListenAndServeTLS(Opts.Server.TlsCert, Opts.Server.TlsKey); !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(err)
+		}
+	}()
+
+	return srv
 }
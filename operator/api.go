@@ -0,0 +1,162 @@
+package operator
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	ApiPathIdentities = "/api/v1/identities"
+	ApiPathNamespaces = "/api/v1/namespaces/"
+)
+
+type (
+	ApiIdentity struct {
+		ResourceId             string              `json:"resourceId"`
+		Name                   string              `json:"name"`
+		ResourceGroup          string              `json:"resourceGroup"`
+		SubscriptionId         string              `json:"subscriptionId"`
+		ClientId               string              `json:"clientId"`
+		PrincipalId            string              `json:"principalId"`
+		TenantId               string              `json:"tenantId"`
+		Tags                   map[string]string   `json:"tags"`
+		KubernetesResourceName string              `json:"kubernetesResourceName"`
+		Namespaces             []string            `json:"namespaces"`
+		Clusters               []string            `json:"clusters,omitempty"`
+		RoleAssignments        []MsiRoleAssignment `json:"roleAssignments,omitempty"`
+		HighPrivilege          bool                `json:"highPrivilege,omitempty"`
+		Status                 map[string]string   `json:"status"`
+		Denials                map[string]string   `json:"denials,omitempty"`
+		ClusterExcluded        bool                `json:"clusterExcluded,omitempty"`
+		SystemAssigned         bool                `json:"systemAssigned,omitempty"`
+	}
+
+	ApiNamespace struct {
+		Namespace  string        `json:"namespace"`
+		Identities []ApiIdentity `json:"identities"`
+	}
+)
+
+// checkBearerToken checks the bearer token of the request (always false if token is empty)
+func checkBearerToken(r *http.Request, token string) bool {
+	requestToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) == 1
+}
+
+// HandleApiIdentities lists all discovered Azure MSIs, optionally filtered by namespace (?namespace=x)
+func (m *MsiOperator) HandleApiIdentities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.writeApiResponse(w, m.apiIdentities(r.URL.Query().Get("namespace"), checkBearerToken(r, m.Conf.Server.ApiToken)))
+}
+
+// HandleApiNamespace lists all discovered Azure MSIs of a namespace (/api/v1/namespaces/{namespace})
+func (m *MsiOperator) HandleApiNamespace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace := strings.Trim(strings.TrimPrefix(r.URL.Path, ApiPathNamespaces), "/")
+	if namespace == "" || strings.Contains(namespace, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	m.writeApiResponse(w, ApiNamespace{
+		Namespace:  namespace,
+		Identities: m.apiIdentities(namespace, checkBearerToken(r, m.Conf.Server.ApiToken)),
+	})
+}
+
+// apiIdentities returns the discovered Azure MSIs, role assignments are only included for authorized requests
+// namespaces only contain the namespaces allowed by cluster filter and policy, the policy decisions of the last sync
+// run are reported (not evaluated per request, namespaces not synced yet are listed until the next sync run)
+func (m *MsiOperator) apiIdentities(namespaceFilter string, withRoleAssignments bool) []ApiIdentity {
+	namespaceFilter = strings.ToLower(namespaceFilter)
+	cluster := m.kubernetes.cluster
+
+	ret := []ApiIdentity{}
+	for _, msiInfo := range m.serviceDiscovery.msi.GetList() {
+		if namespaceFilter != "" && !contains(msiInfo.KubernetesNamespace, namespaceFilter) {
+			continue
+		}
+
+		resourceId := to.String(msiInfo.AzureResourceId)
+		identity := ApiIdentity{
+			ResourceId:             resourceId,
+			Name:                   to.String(msiInfo.AzureResourceName),
+			ResourceGroup:          to.String(msiInfo.AzureResourceGroup),
+			SubscriptionId:         to.String(msiInfo.AzureSubscriptionId),
+			KubernetesResourceName: to.String(msiInfo.KubernetesResourceName),
+			Namespaces:             []string{},
+			Clusters:               msiInfo.KubernetesClusters,
+			Status:                 m.serviceDiscovery.msi.GetStatus(resourceId),
+			Denials:                m.serviceDiscovery.msi.GetDenials(resourceId),
+			ClusterExcluded:        !msiInfo.TargetsCluster(cluster.name),
+			SystemAssigned:         msiInfo.SystemAssigned,
+		}
+
+		if !identity.ClusterExcluded {
+			for _, k8sNamespace := range msiInfo.KubernetesNamespace {
+				if identity.Status[k8sNamespace] != MsiSyncStatusDenied {
+					identity.Namespaces = append(identity.Namespaces, k8sNamespace)
+				}
+			}
+		}
+
+		if namespaceFilter != "" && !contains(identity.Namespaces, namespaceFilter) {
+			continue
+		}
+
+		if withRoleAssignments {
+			identity.RoleAssignments = msiInfo.RoleAssignments
+			identity.HighPrivilege = len(highPrivilegeRoleAssignments(msiInfo.RoleAssignments, m.Conf.Azure.RoleAssignments.HighPrivilege)) > 0
+		}
+
+		if msiInfo.Resource != nil {
+			identity.Tags = to.StringMap(msiInfo.Resource.Tags)
+			if msiInfo.Resource.UserAssignedIdentityProperties != nil {
+				identity.ClientId = msiInfo.Resource.ClientID.String()
+				identity.PrincipalId = msiInfo.Resource.PrincipalID.String()
+				identity.TenantId = msiInfo.Resource.TenantID.String()
+			}
+		}
+
+		ret = append(ret, identity)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ResourceId < ret[j].ResourceId
+	})
+
+	return ret
+}
+
+func (m *MsiOperator) writeApiResponse(w http.ResponseWriter, data interface{}) {
+	m.writeApiResponseWithStatus(w, http.StatusOK, data)
+}
+
+// writeApiResponseWithStatus writes the data as json response, status is only written if the response could be generated
+func (m *MsiOperator) writeApiResponseWithStatus(w http.ResponseWriter, status int, data interface{}) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		m.Logger.Error(err)
+		http.Error(w, "unable to generate response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(content); err != nil {
+		m.Logger.Error(err)
+	}
+}
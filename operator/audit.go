@@ -0,0 +1,325 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// audit outputs
+	AuditOutputStdout  = "stdout"
+	AuditOutputFile    = "file"
+	AuditOutputWebhook = "webhook"
+
+	// audit actions
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionRewire = "rewire"
+
+	// audit results
+	AuditResultSuccess = "success"
+	AuditResultError   = "error"
+
+	// max number of queued audit records for webhook output
+	auditWebhookQueueSize = 1000
+)
+
+type (
+	// AuditRecord is a change of a Kubernetes resource made by the operator
+	AuditRecord struct {
+		Tag             string    `json:"tag"`
+		Time            time.Time `json:"time"`
+		Action          string    `json:"action"`
+		Result          string    `json:"result"`
+		Error           string    `json:"error,omitempty"`
+		Kind            string    `json:"kind"`
+		Cluster         string    `json:"cluster,omitempty"`
+		Namespace       string    `json:"namespace,omitempty"`
+		Name            string    `json:"name"`
+		AzureResourceId string    `json:"azureResourceId"`
+		Trigger         string    `json:"trigger"`
+		Actor           string    `json:"actor"`
+		Instance        string    `json:"instance,omitempty"`
+		SpecHashBefore  string    `json:"specHashBefore,omitempty"`
+		SpecHashAfter   string    `json:"specHashAfter"`
+	}
+
+	auditLog struct {
+		logger   *zap.SugaredLogger
+		sink     auditSink
+		tag      string
+		actor    string
+		instance string
+	}
+
+	// auditSink writes serialized audit records (one JSON document per line)
+	auditSink interface {
+		write(line []byte) error
+		close(ctx context.Context) error
+	}
+
+	auditWriterSink struct {
+		lock   sync.Mutex
+		writer io.Writer
+		closer io.Closer
+	}
+
+	auditWebhookSink struct {
+		lock   sync.Mutex
+		closed bool
+		logger *zap.SugaredLogger
+		client *http.Client
+		url    string
+		token  string
+		queue  chan []byte
+		done   chan struct{}
+	}
+
+	syncTriggerContextKey struct{}
+)
+
+func (m *MsiOperator) initAudit() {
+	m.audit = &auditLog{
+		logger: m.Logger,
+		tag:    m.Conf.Audit.Tag,
+		actor:  m.Conf.Audit.Actor,
+	}
+
+	if m.audit.actor == "" {
+		m.audit.actor = m.Conf.Webhook.ServiceAccount
+	}
+
+	if m.Conf.Instance.Pod != nil {
+		m.audit.instance = *m.Conf.Instance.Pod
+	}
+
+	switch m.Conf.Audit.Output {
+	case AuditOutputStdout:
+		m.audit.sink = &auditWriterSink{writer: os.Stdout}
+	case AuditOutputFile:
+		if m.Conf.Audit.File == "" {
+			m.Logger.Panic("audit output file requires --audit.file")
+		}
+
+		// #nosec G304 -- path is configured by operator admin
+		file, err := os.OpenFile(filepath.Clean(m.Conf.Audit.File), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			m.Logger.Panic(err)
+		}
+		m.audit.sink = &auditWriterSink{writer: file, closer: file}
+	case AuditOutputWebhook:
+		if m.Conf.Audit.WebhookUrl == "" {
+			m.Logger.Panic("audit output webhook requires --audit.webhook.url")
+		}
+		m.audit.sink = newAuditWebhookSink(m.Logger, m.Conf.Audit.WebhookUrl, m.Conf.Audit.WebhookToken, m.Conf.Audit.WebhookTimeout)
+	default:
+		return
+	}
+
+	m.Logger.Infof("writing audit log to %v", m.Conf.Audit.Output)
+}
+
+// shutdownAudit flushes pending audit records
+func (m *MsiOperator) shutdownAudit(ctx context.Context) {
+	if m.audit == nil || m.audit.sink == nil {
+		return
+	}
+
+	if err := m.audit.sink.close(ctx); err != nil {
+		m.Logger.Warnf("failed to close audit log: %v", err)
+	}
+}
+
+// auditChange writes an audit record for a change of a Kubernetes resource (before is nil for created resources)
+func (m *MsiOperator) auditChange(ctx context.Context, action string, msiInfo MsiResourceInfo, kind, namespace, name string, before, after *unstructured.Unstructured, err error) {
+	record := AuditRecord{
+		Action:          action,
+		Kind:            kind,
+		Namespace:       namespace,
+		Name:            name,
+		AzureResourceId: to.String(msiInfo.AzureResourceId),
+		SpecHashAfter:   auditSpecHash(after),
+	}
+
+	if before != nil {
+		record.SpecHashBefore = auditSpecHash(before)
+	}
+
+	m.audit.write(ctx, record, err)
+}
+
+// write completes and writes the audit record, errors of the audit log are only logged
+func (a *auditLog) write(ctx context.Context, record AuditRecord, err error) {
+	if a == nil || a.sink == nil {
+		return
+	}
+
+	record.Tag = a.tag
+	record.Time = time.Now().UTC()
+	record.Trigger = syncTriggerFromContext(ctx)
+	record.Cluster = clusterFromContext(ctx)
+	record.Actor = a.actor
+	record.Instance = a.instance
+	record.Result = AuditResultSuccess
+	if err != nil {
+		record.Result = AuditResultError
+		record.Error = err.Error()
+	}
+
+	line, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		a.logger.Errorf("failed to encode audit record: %v", marshalErr)
+		return
+	}
+
+	if writeErr := a.sink.write(line); writeErr != nil {
+		a.logger.Errorf("failed to write audit record: %v", writeErr)
+	}
+}
+
+// auditSpecHash returns a hash of the spec, labels and annotations of a Kubernetes resource
+func auditSpecHash(obj *unstructured.Unstructured) string {
+	if obj == nil {
+		return ""
+	}
+
+	spec, _, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec")
+	data, err := json.Marshal(map[string]interface{}{
+		"spec":        spec,
+		"labels":      obj.GetLabels(),
+		"annotations": obj.GetAnnotations(),
+	})
+	if err != nil {
+		return ""
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// contextWithSyncTrigger returns a context containing the trigger of the sync (used for audit records)
+func contextWithSyncTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, syncTriggerContextKey{}, trigger)
+}
+
+// syncTriggerFromContext returns the trigger of the sync
+func syncTriggerFromContext(ctx context.Context) string {
+	if trigger, ok := ctx.Value(syncTriggerContextKey{}).(string); ok {
+		return trigger
+	}
+	return "unknown"
+}
+
+func (s *auditWriterSink) write(line []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (s *auditWriterSink) close(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+// newAuditWebhookSink creates a webhook sink, records are sent asynchronously (one request per record)
+func newAuditWebhookSink(logger *zap.SugaredLogger, url, token string, timeout time.Duration) *auditWebhookSink {
+	s := &auditWebhookSink{
+		logger: logger,
+		client: &http.Client{Timeout: timeout},
+		url:    url,
+		token:  token,
+		queue:  make(chan []byte, auditWebhookQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		for line := range s.queue {
+			if err := s.send(line); err != nil {
+				s.logger.Errorf("failed to send audit record to webhook: %v", err)
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *auditWebhookSink) write(line []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("audit webhook is closed, dropping record: %s", line)
+	}
+
+	select {
+	case s.queue <- line:
+		return nil
+	default:
+		return fmt.Errorf("audit webhook queue is full (%d records), dropping record: %s", auditWebhookQueueSize, line)
+	}
+}
+
+func (s *auditWebhookSink) send(line []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// close waits (until ctx is cancelled) for queued records
+func (s *auditWebhookSink) close(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("audit webhook queue not flushed: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,90 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAuditSpecHash(t *testing.T) {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":   "test",
+				"labels": map[string]interface{}{"foo": "bar"},
+			},
+			"spec": map[string]interface{}{"clientID": "xxx"},
+		},
+	}
+	hash := auditSpecHash(obj)
+
+	// metadata which is not managed by the operator doesn't change the hash
+	unrelated := obj.DeepCopy()
+	unrelated.SetResourceVersion("12345")
+	if auditSpecHash(unrelated) != hash {
+		t.Errorf("expected same hash for changed resourceVersion")
+	}
+
+	changedSpec := obj.DeepCopy()
+	if err := unstructured.SetNestedField(changedSpec.Object, "yyy", "spec", "clientID"); err != nil {
+		t.Fatal(err)
+	}
+	if auditSpecHash(changedSpec) == hash {
+		t.Errorf("expected different hash for changed spec")
+	}
+
+	changedLabels := obj.DeepCopy()
+	changedLabels.SetLabels(map[string]string{"foo": "baz"})
+	if auditSpecHash(changedLabels) == hash {
+		t.Errorf("expected different hash for changed labels")
+	}
+
+	if auditSpecHash(nil) != "" {
+		t.Errorf("expected empty hash for nil object")
+	}
+}
+
+func TestAuditLogWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	audit := &auditLog{
+		logger: zap.NewNop().Sugar(),
+		sink:   &auditWriterSink{writer: buf},
+		tag:    "audit",
+		actor:  "system:serviceaccount:kube-system:azure-msi-operator",
+	}
+
+	ctx := contextWithSyncTrigger(context.Background(), SyncRunTriggerWatchNamespace)
+	audit.write(ctx, AuditRecord{Action: AuditActionCreate, Kind: "AzureIdentity", Name: "test"}, nil)
+	audit.write(context.Background(), AuditRecord{Action: AuditActionUpdate, Kind: "AzureIdentity", Name: "test"}, errors.New("conflict"))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(lines))
+	}
+
+	records := []AuditRecord{}
+	for _, line := range lines {
+		record := AuditRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	if records[0].Trigger != SyncRunTriggerWatchNamespace || records[0].Result != AuditResultSuccess || records[0].Tag != "audit" || records[0].Actor != audit.actor {
+		t.Errorf("unexpected audit record %+v", records[0])
+	}
+
+	if records[1].Trigger != "unknown" || records[1].Result != AuditResultError || records[1].Error != "conflict" {
+		t.Errorf("unexpected audit record %+v", records[1])
+	}
+
+	// disabled audit log
+	var disabled *auditLog
+	disabled.write(ctx, AuditRecord{}, nil)
+}
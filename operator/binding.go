@@ -0,0 +1,208 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// AzureIdentityBinding lookup strategies
+	BindingLookupLabels     = "labels"
+	BindingLookupAnnotation = "annotation"
+	BindingLookupAlias      = "alias"
+
+	// length of the resource alias (hex encoded sha256 hash of resource id)
+	msiResourceAliasLength = 20
+)
+
+// lookupAzureIdentityBindings returns all AzureIdentityBindings in namespace referencing the Azure MSI
+// using the configured lookup strategy
+func (m *MsiOperator) lookupAzureIdentityBindings(ctx context.Context, cluster *kubernetesCluster, msiInfo MsiResourceInfo, k8sNamespace string) (*unstructured.UnstructuredList, error) {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
+	listOpts := metav1.ListOptions{}
+
+	switch m.Conf.AzureIdentity.Binding.Lookup {
+	case BindingLookupAnnotation:
+		// annotations cannot be used as selector, filtered after list
+	case BindingLookupAlias:
+		listOpts.LabelSelector = fmt.Sprintf("%s=%s", m.labelName("alias"), msiResourceAlias(to.String(msiInfo.AzureResourceId)))
+	default:
+		labelNameSubscription := m.labelName("subscription")
+		labelValueSubscription := to.String(msiInfo.AzureSubscriptionId)
+
+		labelNameResourceGroup := m.labelName("resourcegroup")
+		labelValueResourceGroup := to.String(msiInfo.AzureResourceGroup)
+
+		labelNameResourceName := m.labelName("name")
+		labelValueResourceName := to.String(msiInfo.AzureResourceName)
+
+		if validationErrors := validation.IsValidLabelValue(labelValueSubscription); len(validationErrors) != 0 {
+			return nil, fmt.Errorf("invalid label value \"%s\" for subscription: %v", labelValueSubscription, validationErrors)
+		}
+
+		if validationErrors := validation.IsValidLabelValue(labelValueResourceGroup); len(validationErrors) != 0 {
+			return nil, fmt.Errorf("invalid label value \"%s\" for resourcegroup: %v", labelValueResourceGroup, validationErrors)
+		}
+
+		if validationErrors := validation.IsValidLabelValue(labelValueResourceName); len(validationErrors) != 0 {
+			return nil, fmt.Errorf("invalid label value \"%s\" for resourcename: %v", labelValueResourceName, validationErrors)
+		}
+
+		listOpts.LabelSelector = fmt.Sprintf(
+			"%s=%s,%s=%s,%s=%s",
+			labelNameSubscription, labelValueSubscription,
+			labelNameResourceGroup, labelValueResourceGroup,
+			labelNameResourceName, labelValueResourceName,
+		)
+	}
+
+	list, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch AzureIdentityBinding from namespace \"%s\": %w", k8sNamespace, err)
+	}
+
+	if m.Conf.AzureIdentity.Binding.Lookup == BindingLookupAnnotation {
+		annotationName := m.labelName("resourceid")
+		items := []unstructured.Unstructured{}
+		for _, item := range list.Items {
+			if strings.EqualFold(item.GetAnnotations()[annotationName], to.String(msiInfo.AzureResourceId)) {
+				items = append(items, item)
+			}
+		}
+		list.Items = items
+	}
+
+	return list, nil
+}
+
+// msiResourceAlias returns a short (label compatible) alias for the Azure MSI resource id
+func msiResourceAlias(resourceId string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(resourceId)))
+	return hex.EncodeToString(hash[:])[:msiResourceAliasLength]
+}
+
+// generateAzureIdentityBinding creates or updates the AzureIdentityBinding (same name as AzureIdentity) for an Azure MSI
+// existing AzureIdentityBindings which are not managed by the operator are not touched
+func (m *MsiOperator) generateAzureIdentityBinding(ctx context.Context, cluster *kubernetesCluster, contextLogger *zap.SugaredLogger, msiInfo MsiResourceInfo, k8sNamespace string) error {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
+	subscriptionId := to.String(msiInfo.AzureSubscriptionId)
+	k8sResourceName := *msiInfo.KubernetesResourceName
+
+	if msiInfo.KubernetesBindingSelector == nil {
+		contextLogger.Debugf("no AzureIdentityBinding selector found for AzureIdentity %v/%v, skipping generation", k8sNamespace, k8sResourceName)
+		return nil
+	}
+
+	azureIdentityBindingObj, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Get(ctx, k8sResourceName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to fetch AzureIdentityBinding \"%s/%s\": %w", k8sNamespace, k8sResourceName, err)
+	}
+
+	if err == nil {
+		// update
+		if azureIdentityBindingObj.GetLabels()[K8sLabelManagedBy] != K8sManagedByValue {
+			contextLogger.Warnf("AzureIdentityBinding \"%s/%s\" already exists and is not managed by operator, skipping generation", k8sNamespace, k8sResourceName)
+			return nil
+		}
+
+		original := azureIdentityBindingObj.DeepCopy()
+		if err := m.applyMsiToAzureIdentityBinding(msiInfo, azureIdentityBindingObj); err != nil {
+			return err
+		}
+
+		if reflect.DeepEqual(original.Object, azureIdentityBindingObj.Object) {
+			contextLogger.Debugf("AzureIdentityBinding \"%s/%s\" is unchanged", k8sNamespace, k8sResourceName)
+			m.history.count(SyncResultUnchanged)
+			return nil
+		}
+
+		contextLogger.Infof("updating AzureIdentityBinding \"%s/%s\"", k8sNamespace, k8sResourceName)
+		_, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Update(ctx, azureIdentityBindingObj, metav1.UpdateOptions{})
+		m.auditChange(ctx, AuditActionUpdate, msiInfo, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, k8sResourceName, original, azureIdentityBindingObj, err)
+		if err != nil {
+			m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+			m.history.count(SyncResultError)
+			return err
+		}
+		m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+		m.history.count(SyncResultUpdated)
+	} else {
+		// create
+		contextLogger.Infof("creating AzureIdentityBinding \"%s/%s\"", k8sNamespace, k8sResourceName)
+
+		azureIdentityBindingObj = &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": k8sResourceName,
+					"labels": map[string]interface{}{
+						K8sLabelManagedBy: K8sManagedByValue,
+					},
+				},
+				"spec": map[string]interface{}{},
+			},
+		}
+
+		if err := m.applyMsiToAzureIdentityBinding(msiInfo, azureIdentityBindingObj); err != nil {
+			return err
+		}
+
+		_, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Create(ctx, azureIdentityBindingObj, metav1.CreateOptions{})
+		m.auditChange(ctx, AuditActionCreate, msiInfo, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, k8sResourceName, nil, azureIdentityBindingObj, err)
+		if err != nil {
+			m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+			m.history.count(SyncResultError)
+			return err
+		}
+		m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+		m.history.count(SyncResultCreated)
+	}
+
+	return nil
+}
+
+func (m *MsiOperator) applyMsiToAzureIdentityBinding(msiInfo MsiResourceInfo, k8sResource *unstructured.Unstructured) error {
+	// main
+	resourceApiVersion := fmt.Sprintf("%s/%s", K8sSchemeAzureIdentityBindingGroup, K8sSchemeAzureIdentityBindingVersion)
+	if err := unstructured.SetNestedField(k8sResource.Object, resourceApiVersion, "apiVersion"); err != nil {
+		return fmt.Errorf("failed to set object apiversion value: %w", err)
+	}
+
+	if err := unstructured.SetNestedField(k8sResource.Object, K8sSchemeAzureIdentityBindingResourceSingular, "kind"); err != nil {
+		return fmt.Errorf("failed to set object kind value: %w", err)
+	}
+
+	// settings
+	if err := unstructured.SetNestedField(k8sResource.Object, *msiInfo.KubernetesResourceName, "spec", "azureIdentity"); err != nil {
+		return fmt.Errorf("failed to set spec.azureIdentity value: %w", err)
+	}
+
+	if err := unstructured.SetNestedField(k8sResource.Object, *msiInfo.KubernetesBindingSelector, "spec", "selector"); err != nil {
+		return fmt.Errorf("failed to set spec.selector value: %w", err)
+	}
+
+	// annotations
+	if err := unstructured.SetNestedField(k8sResource.Object, to.String(msiInfo.AzureResourceId), "metadata", "annotations", m.labelName("resourceid")); err != nil {
+		return fmt.Errorf("failed to set metadata.annotations[%v] value: %w", m.labelName("resourceid"), err)
+	}
+
+	// labels
+	resourceInfo := azure.Resource{
+		SubscriptionID: to.String(msiInfo.AzureSubscriptionId),
+		ResourceGroup:  to.String(msiInfo.AzureResourceGroup),
+		ResourceName:   to.String(msiInfo.AzureResourceName),
+	}
+	return m.applyMsiLabelsToK8sObject(to.String(msiInfo.AzureResourceId), resourceInfo, k8sResource)
+}
@@ -0,0 +1,247 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	// metrics are registered globally, so they are shared by all test operators
+	testPrometheusOnce sync.Once
+	testPrometheus     *MsiOperator
+)
+
+// newTestOperator returns an operator using a fake Kubernetes client containing the objects
+func newTestOperator(objects ...runtime.Object) *MsiOperator {
+	testPrometheusOnce.Do(func() {
+		testPrometheus = &MsiOperator{}
+		testPrometheus.initPrometheus()
+	})
+
+	m := &MsiOperator{
+		Logger:    zap.NewNop().Sugar(),
+		UserAgent: "azure-msi-operator/test",
+		health:    newHealthStatus(),
+		history:   newSyncRunHistory(1),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.stopCtx = m.ctx
+	m.runLock = semaphore.NewWeighted(1)
+	m.upsertLock = semaphore.NewWeighted(1)
+	m.initTracing()
+	m.prometheus = testPrometheus.prometheus
+	m.serviceDiscovery.msi = NewMsiResourceList()
+	m.Conf.Kubernetes.LabelFormat = "msi.azure.k8s.io/%s"
+	m.Conf.Kubernetes.NamespaceMissing = NamespaceMissingError
+	m.Conf.Kubernetes.WriteConcurrency = 4
+	m.Conf.AzureIdentity.Binding.Lookup = BindingLookupLabels
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}:                      "AzureIdentityList",
+			{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}: "AzureIdentityBindingList",
+			{Group: "", Version: "v1", Resource: "namespaces"}: "NamespaceList",
+			{Group: "", Version: "v1", Resource: "secrets"}:    "SecretList",
+		},
+		objects...,
+	)
+	m.kubernetes.client = client
+	m.kubernetes.cluster = &kubernetesCluster{name: "local", local: true, client: client}
+	m.kubernetes.remoteClusters = map[string]*kubernetesCluster{}
+	return m
+}
+
+func testAzureIdentityBinding(namespace, name string, labels, annotations map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": K8sSchemeAzureIdentityBindingGroup + "/" + K8sSchemeAzureIdentityBindingVersion,
+			"kind":       K8sSchemeAzureIdentityBindingResourceSingular,
+			"metadata": map[string]interface{}{
+				"name":        name,
+				"namespace":   namespace,
+				"labels":      labels,
+				"annotations": annotations,
+			},
+			"spec": map[string]interface{}{},
+		},
+	}
+}
+
+func TestLookupAzureIdentityBindings(t *testing.T) {
+	// names exceeding label value limits
+	resourceGroup := "rg-" + strings.Repeat("a", 80)
+	name := "msi-" + strings.Repeat("b", 100)
+	resourceId := "/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups/" + resourceGroup + "/providers/microsoft.managedidentity/userassignedidentities/" + name
+	msiInfo := MsiResourceInfo{
+		AzureResourceId:     to.StringPtr(resourceId),
+		AzureResourceGroup:  to.StringPtr(resourceGroup),
+		AzureResourceName:   to.StringPtr(name),
+		AzureSubscriptionId: to.StringPtr("00000000-0000-0000-0000-000000000001"),
+	}
+
+	m := newTestOperator(
+		testAzureIdentityBinding("team-a", "by-alias", map[string]interface{}{"msi.azure.k8s.io/alias": msiResourceAlias(resourceId)}, nil),
+		testAzureIdentityBinding("team-a", "by-annotation", nil, map[string]interface{}{"msi.azure.k8s.io/resourceid": strings.ToUpper(resourceId)}),
+		testAzureIdentityBinding("team-a", "other", map[string]interface{}{"msi.azure.k8s.io/alias": msiResourceAlias("/other")}, map[string]interface{}{"msi.azure.k8s.io/resourceid": "/other"}),
+		testAzureIdentityBinding("team-b", "by-alias", map[string]interface{}{"msi.azure.k8s.io/alias": msiResourceAlias(resourceId)}, nil),
+	)
+
+	tests := map[string]string{
+		BindingLookupAlias:      "by-alias",
+		BindingLookupAnnotation: "by-annotation",
+	}
+	for lookup, expected := range tests {
+		m.Conf.AzureIdentity.Binding.Lookup = lookup
+		list, err := m.lookupAzureIdentityBindings(context.Background(), m.kubernetes.cluster, msiInfo, "team-a")
+		if err != nil {
+			t.Fatalf("%s: %v", lookup, err)
+		}
+		if len(list.Items) != 1 || list.Items[0].GetName() != expected {
+			t.Errorf("%s: expected only %s, got %d items", lookup, expected, len(list.Items))
+		}
+	}
+
+	// label lookup requires valid label values
+	m.Conf.AzureIdentity.Binding.Lookup = BindingLookupLabels
+	if _, err := m.lookupAzureIdentityBindings(context.Background(), m.kubernetes.cluster, msiInfo, "team-a"); err == nil {
+		t.Errorf("expected error for label lookup with invalid label values")
+	}
+}
+
+func TestApplyMsiToAzureIdentityBindingLabels(t *testing.T) {
+	m := newTestOperator()
+
+	msiInfo := MsiResourceInfo{
+		AzureResourceId:           to.StringPtr("/subscriptions/xxx/resourcegroups/rg/providers/microsoft.managedidentity/userassignedidentities/foo"),
+		AzureResourceGroup:        to.StringPtr("rg"),
+		AzureResourceName:         to.StringPtr("foo"),
+		AzureSubscriptionId:       to.StringPtr("xxx"),
+		KubernetesResourceName:    to.StringPtr("foo"),
+		KubernetesBindingSelector: to.StringPtr("foo"),
+	}
+	obj := testAzureIdentityBinding("team-a", "foo", map[string]interface{}{}, map[string]interface{}{})
+	if err := m.applyMsiToAzureIdentityBinding(msiInfo, obj); err != nil {
+		t.Fatal(err)
+	}
+	if labels := obj.GetLabels(); labels["msi.azure.k8s.io/resourcegroup"] != "rg" || labels["msi.azure.k8s.io/name"] != "foo" {
+		t.Fatalf("expected resourcegroup and name labels, got %v", labels)
+	}
+
+	// too long for label values, previous labels are removed
+	msiInfo.AzureResourceGroup = to.StringPtr(strings.Repeat("r", 90))
+	msiInfo.AzureResourceName = to.StringPtr(strings.Repeat("n", 128))
+	if err := m.applyMsiToAzureIdentityBinding(msiInfo, obj); err != nil {
+		t.Fatal(err)
+	}
+	labels := obj.GetLabels()
+	if _, exists := labels["msi.azure.k8s.io/resourcegroup"]; exists {
+		t.Errorf("expected no resourcegroup label, got %v", labels)
+	}
+	if _, exists := labels["msi.azure.k8s.io/name"]; exists {
+		t.Errorf("expected no name label, got %v", labels)
+	}
+	if labels["msi.azure.k8s.io/alias"] != msiResourceAlias(to.String(msiInfo.AzureResourceId)) || labels["msi.azure.k8s.io/subscription"] != "xxx" {
+		t.Errorf("expected alias and subscription labels, got %v", labels)
+	}
+}
+
+func TestGenerateAzureIdentityBinding(t *testing.T) {
+	m := newTestOperator(
+		testAzureIdentityBinding("team-b", "foo", map[string]interface{}{}, nil),
+	)
+	msiInfo := testSyncMsiResourceInfo("foo", "team-a", "team-b")
+	msiInfo.KubernetesBindingSelector = to.StringPtr("foo-selector")
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
+
+	selector := func(namespace string) string {
+		t.Helper()
+		obj, err := m.kubernetes.client.Resource(gvr).Namespace(namespace).Get(context.Background(), "foo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		val, _, _ := unstructured.NestedString(obj.Object, "spec", "selector")
+		return val
+	}
+
+	generate := func(namespace string) *SyncRun {
+		t.Helper()
+		m.history.begin(SyncRunTriggerManual)
+		err := m.generateAzureIdentityBinding(context.Background(), m.kubernetes.cluster, m.Logger, msiInfo, namespace)
+		run := m.history.end(err, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+
+	// create
+	if run := generate("team-a"); run.Created != 1 {
+		t.Errorf("expected created AzureIdentityBinding, got %+v", run)
+	}
+	if val := selector("team-a"); val != "foo-selector" {
+		t.Errorf("expected selector foo-selector, got %q", val)
+	}
+
+	// unchanged
+	if run := generate("team-a"); run.Unchanged != 1 {
+		t.Errorf("expected unchanged AzureIdentityBinding, got %+v", run)
+	}
+
+	// update
+	msiInfo.KubernetesBindingSelector = to.StringPtr("bar-selector")
+	if run := generate("team-a"); run.Updated != 1 {
+		t.Errorf("expected updated AzureIdentityBinding, got %+v", run)
+	}
+	if val := selector("team-a"); val != "bar-selector" {
+		t.Errorf("expected selector bar-selector, got %q", val)
+	}
+
+	// unmanaged AzureIdentityBindings are left alone
+	if run := generate("team-b"); run.Created != 0 || run.Updated != 0 {
+		t.Errorf("expected unmanaged AzureIdentityBinding to be skipped, got %+v", run)
+	}
+	if val := selector("team-b"); val != "" {
+		t.Errorf("expected unmanaged AzureIdentityBinding to be unchanged, got selector %q", val)
+	}
+}
+
+func TestGenerateAzureIdentityBindingSkippedOnFailedAzureIdentity(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.AzureIdentity.Binding.Generate = true
+	msiInfo := testSyncMsiResourceInfo("foo", "team-a")
+	msiInfo.KubernetesBindingSelector = to.StringPtr("foo-selector")
+	m.serviceDiscovery.msi.Update(msiInfo)
+
+	client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+	client.PrependReactor("create", K8sSchemeAzureIdentityResourcePlural, func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(testAzureIdentityGvr.GroupResource(), "foo", fmt.Errorf("create not allowed"))
+	})
+
+	m.history.begin(SyncRunTriggerManual)
+	err := m.upsertCluster(context.Background(), m.kubernetes.cluster, newNamespaceFilter(nil), m.serviceDiscovery.msi.Version(), true, false)
+	run := m.history.end(err, m.serviceDiscovery.msi.Version())
+	if run.Errors != 1 {
+		t.Errorf("expected only the AzureIdentity to fail, got %+v", run)
+	}
+
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource == K8sSchemeAzureIdentityBindingResourcePlural {
+			t.Errorf("expected no AzureIdentityBinding request, got %s", action.GetVerb())
+		}
+	}
+}
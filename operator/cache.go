@@ -0,0 +1,134 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type (
+	// azureIdentityCache contains the managed AzureIdentities (listed at the start of an upsert) to avoid a Get per resource
+	// AzureIdentities without managed-by label (eg. created by older versions) are not cached
+	azureIdentityCache struct {
+		items map[string]unstructured.Unstructured
+	}
+
+	// upsertCache contains the caches of an upsert shared by the (concurrent) upserts of the Azure MSIs
+	upsertCache struct {
+		azureIdentities *azureIdentityCache
+
+		lock       sync.Mutex
+		namespaces map[string]*namespaceCheck
+	}
+
+	// namespaceFilter contains the namespaces of an upsert, empty filter matches all namespaces
+	namespaceFilter map[string]bool
+
+	// namespaceCheck is the result of the namespace check, each namespace is only checked once per upsert
+	namespaceCheck struct {
+		once   sync.Once
+		exists bool
+		err    error
+
+		// namespace labels for policy evaluation
+		labelsOnce sync.Once
+		labels     map[string]string
+		labelsErr  error
+	}
+)
+
+func newUpsertCache(azureIdentities *azureIdentityCache) *upsertCache {
+	return &upsertCache{
+		azureIdentities: azureIdentities,
+		namespaces:      map[string]*namespaceCheck{},
+	}
+}
+
+// namespaceCheck returns the namespace check of the namespace
+func (c *upsertCache) namespaceCheck(namespace string) *namespaceCheck {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	check, exists := c.namespaces[namespace]
+	if !exists {
+		check = &namespaceCheck{}
+		c.namespaces[namespace] = check
+	}
+	return check
+}
+
+func newNamespaceFilter(namespaces []string) namespaceFilter {
+	filter := namespaceFilter{}
+	for _, namespace := range namespaces {
+		filter[namespace] = true
+	}
+	return filter
+}
+
+// matches returns true if the namespace is part of the filter (or filter is empty)
+func (f namespaceFilter) matches(namespace string) bool {
+	return len(f) == 0 || f[namespace]
+}
+
+// String returns the sorted namespaces of the filter
+func (f namespaceFilter) String() string {
+	namespaces := make([]string, 0, len(f))
+	for namespace := range f {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return strings.Join(namespaces, ", ")
+}
+
+// listAzureIdentityCache lists the managed AzureIdentities of the namespaces of the filter
+// a single namespace is listed namespace scoped, otherwise AzureIdentities are listed cluster-wide
+func (m *MsiOperator) listAzureIdentityCache(ctx context.Context, cluster *kubernetesCluster, filter namespaceFilter) (*azureIdentityCache, error) {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
+	listOpts := metav1.ListOptions{
+		LabelSelector: K8sLabelManagedBy + "=" + K8sManagedByValue,
+	}
+
+	var (
+		list *unstructured.UnstructuredList
+		err  error
+	)
+	if len(filter) == 1 {
+		for namespace := range filter {
+			list, err = cluster.client.Resource(gvr).Namespace(namespace).List(ctx, listOpts)
+		}
+	} else {
+		list, err = cluster.client.Resource(gvr).List(ctx, listOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list AzureIdentities: %w", err)
+	}
+
+	cache := &azureIdentityCache{
+		items: map[string]unstructured.Unstructured{},
+	}
+	for _, item := range list.Items {
+		if !filter.matches(item.GetNamespace()) {
+			continue
+		}
+		cache.items[item.GetNamespace()+"/"+item.GetName()] = item
+	}
+	return cache, nil
+}
+
+// get returns a copy of the cached AzureIdentity, nil if not cached (or cache is disabled)
+func (c *azureIdentityCache) get(namespace, name string) *unstructured.Unstructured {
+	if c == nil {
+		return nil
+	}
+
+	if item, exists := c.items[namespace+"/"+name]; exists {
+		return item.DeepCopy()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"context"
+	"testing"
+)
+
+func TestListAzureIdentityCacheNamespaceFilter(t *testing.T) {
+	managed := map[string]interface{}{K8sLabelManagedBy: K8sManagedByValue}
+	m := newTestOperator(
+		testAzureIdentity("team-a", "foo", managed),
+		testAzureIdentity("team-b", "foo", managed),
+		testAzureIdentity("team-c", "foo", managed),
+		testAzureIdentity("team-a", "unmanaged", nil),
+	)
+
+	tests := []struct {
+		namespaces []string
+		expected   []string
+	}{
+		{nil, []string{"team-a/foo", "team-b/foo", "team-c/foo"}},
+		{[]string{"team-a"}, []string{"team-a/foo"}},
+		{[]string{"team-a", "team-c"}, []string{"team-a/foo", "team-c/foo"}},
+	}
+
+	for _, test := range tests {
+		cache, err := m.listAzureIdentityCache(context.Background(), m.kubernetes.cluster, newNamespaceFilter(test.namespaces))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(cache.items) != len(test.expected) {
+			t.Errorf("%v: expected %v, got %d items", test.namespaces, test.expected, len(cache.items))
+		}
+		for _, key := range test.expected {
+			if _, exists := cache.items[key]; !exists {
+				t.Errorf("%v: expected %s in cache", test.namespaces, key)
+			}
+		}
+	}
+}
+
+func TestNamespaceFilter(t *testing.T) {
+	if filter := newNamespaceFilter(nil); !filter.matches("team-a") {
+		t.Errorf("expected empty filter to match all namespaces")
+	}
+
+	filter := newNamespaceFilter([]string{"team-b", "team-a"})
+	if !filter.matches("team-a") || filter.matches("team-c") {
+		t.Errorf("expected filter to match only its namespaces")
+	}
+	if filter.String() != "team-a, team-b" {
+		t.Errorf("expected sorted namespaces, got %q", filter.String())
+	}
+}
@@ -0,0 +1,280 @@
+package operator
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// data keys of kubeconfig secrets of additional clusters
+	ClusterSecretKeyKubeconfig        = "kubeconfig"
+	ClusterSecretKeyName              = "name"
+	ClusterSecretKeyNamespaceTemplate = "namespaceTemplate"
+)
+
+type (
+	// kubernetesCluster is a target cluster for the Kubernetes resources of the Azure MSIs
+	kubernetesCluster struct {
+		name   string
+		local  bool
+		client dynamic.Interface
+
+		// namespace template of the cluster (default namespace template is used if nil)
+		namespaceTemplate *template.Template
+
+		// resourceVersion of the kubeconfig secret, the client is only recreated if the secret was changed
+		secretResourceVersion string
+	}
+
+	clusterContextKey struct{}
+)
+
+// targetClusters returns the clusters of an upsert, in multi-cluster mode the kubeconfig secrets are (re)loaded
+// clusters with invalid kubeconfig secrets are logged and skipped, they don't affect the other clusters
+func (m *MsiOperator) targetClusters(ctx context.Context) []*kubernetesCluster {
+	if !m.Conf.MultiCluster.Enabled {
+		return []*kubernetesCluster{m.kubernetes.cluster}
+	}
+
+	clusters := []*kubernetesCluster{}
+	if !m.Conf.MultiCluster.LocalDisable {
+		clusters = append(clusters, m.kubernetes.cluster)
+	}
+
+	if err := m.loadRemoteClusters(ctx); err != nil {
+		// continue with the clusters of the last successful load
+		m.Logger.Errorf("failed to load kubeconfig secrets, using previously loaded clusters: %v", err)
+		m.history.addError("failed to load kubeconfig secrets: %v", err)
+	}
+
+	secretNames := []string{}
+	for secretName := range m.kubernetes.remoteClusters {
+		secretNames = append(secretNames, secretName)
+	}
+	sort.Strings(secretNames)
+
+	for _, secretName := range secretNames {
+		cluster := m.kubernetes.remoteClusters[secretName]
+		if containsCluster(clusters, cluster.name) {
+			m.Logger.Errorf("duplicate cluster name \"%s\" in kubeconfig secret \"%s\", skipping cluster", cluster.name, secretName)
+			m.history.addError("duplicate cluster name \"%s\" in kubeconfig secret \"%s\"", cluster.name, secretName)
+			continue
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
+
+// loadRemoteClusters updates the additional clusters from the kubeconfig secrets in the operator namespace
+// must only be called while holding the upsert lock
+func (m *MsiOperator) loadRemoteClusters(ctx context.Context) error {
+	gvr := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "secrets"}
+	list, err := m.kubernetes.client.Resource(gvr).Namespace(*m.Conf.Instance.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: m.Conf.MultiCluster.SecretSelector,
+	})
+	if err != nil {
+		return err
+	}
+
+	clusters := map[string]*kubernetesCluster{}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		secretName := secret.GetName()
+
+		if cluster, exists := m.kubernetes.remoteClusters[secretName]; exists && cluster.secretResourceVersion == secret.GetResourceVersion() {
+			// unchanged
+			clusters[secretName] = cluster
+			continue
+		}
+
+		cluster, err := m.newRemoteCluster(secret)
+		if err != nil {
+			m.Logger.Errorf("invalid kubeconfig secret \"%s\", skipping cluster: %v", secretName, err)
+			m.history.addError("invalid kubeconfig secret \"%s\": %v", secretName, err)
+			continue
+		}
+
+		m.Logger.Infof("loaded cluster \"%s\" from kubeconfig secret \"%s\"", cluster.name, secretName)
+		clusters[secretName] = cluster
+	}
+
+	// remove metrics of removed clusters
+	for secretName, cluster := range m.kubernetes.remoteClusters {
+		if current, exists := clusters[secretName]; !exists || current.name != cluster.name {
+			m.Logger.Infof("removed cluster \"%s\" (kubeconfig secret \"%s\")", cluster.name, secretName)
+			m.prometheus.clusterUp.DeleteLabelValues(cluster.name)
+			m.prometheus.clusterLastSync.DeleteLabelValues(cluster.name)
+			m.prometheus.clusterDuration.DeleteLabelValues(cluster.name)
+			m.prometheus.clusterErrors.DeleteLabelValues(cluster.name)
+			m.prometheus.clusterExcluded.DeleteLabelValues(cluster.name)
+			m.prometheus.policyDenials.DeletePartialMatch(prometheus.Labels{"cluster": cluster.name})
+		}
+	}
+
+	m.kubernetes.remoteClusters = clusters
+	return nil
+}
+
+// newRemoteCluster creates the client of an additional cluster from the kubeconfig secret
+func (m *MsiOperator) newRemoteCluster(secret *unstructured.Unstructured) (*kubernetesCluster, error) {
+	data, err := secretData(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeconfig, exists := data[ClusterSecretKeyKubeconfig]
+	if !exists || len(kubeconfig) == 0 {
+		return nil, fmt.Errorf("secret doesn't contain \"%s\"", ClusterSecretKeyKubeconfig)
+	}
+
+	kubeconf, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+
+	// client side rate limiting (per cluster)
+	kubeconf.QPS = m.Conf.Kubernetes.QPS
+	kubeconf.Burst = m.Conf.Kubernetes.Burst
+
+	// unreachable clusters must not block the other clusters
+	kubeconf.Timeout = m.Conf.MultiCluster.Timeout
+
+	kubeconf.Wrap(m.wrapKubernetesTransport)
+
+	client, err := dynamic.NewForConfig(kubeconf)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &kubernetesCluster{
+		name:                  strings.ToLower(secret.GetName()),
+		client:                client,
+		secretResourceVersion: secret.GetResourceVersion(),
+	}
+
+	if val := strings.TrimSpace(string(data[ClusterSecretKeyName])); val != "" {
+		cluster.name = strings.ToLower(val)
+	}
+
+	if val := string(data[ClusterSecretKeyNamespaceTemplate]); val != "" {
+		cluster.namespaceTemplate, err = template.New("msiNamespace").Parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid \"%s\": %w", ClusterSecretKeyNamespaceTemplate, err)
+		}
+	}
+
+	return cluster, nil
+}
+
+// secretData returns the decoded data of a secret
+func secretData(secret *unstructured.Unstructured) (map[string][]byte, error) {
+	encoded, _, err := unstructured.NestedStringMap(secret.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret data: %w", err)
+	}
+
+	ret := map[string][]byte{}
+	for key, val := range encoded {
+		decoded, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode secret data \"%s\": %w", key, err)
+		}
+		ret[key] = decoded
+	}
+	return ret, nil
+}
+
+// clusterMsiList returns the Azure MSIs of the snapshot which are targeting the cluster (cluster filter)
+// namespaces are rendered using the namespace template of the cluster (if set)
+func (m *MsiOperator) clusterMsiList(cluster *kubernetesCluster) []MsiResourceInfo {
+	ret := []MsiResourceInfo{}
+	excluded := 0
+	for _, msiInfo := range m.serviceDiscovery.msi.GetList() {
+		if msiInfo.SyncDisabled {
+			// only API and inventory
+			continue
+		}
+
+		if !msiInfo.TargetsCluster(cluster.name) {
+			excluded++
+			continue
+		}
+
+		if cluster.namespaceTemplate != nil && msiInfo.Resource != nil {
+			templateData, err := newMsiTemplateData(msiInfo.Resource, cluster.name)
+			if err == nil {
+				msiInfo.KubernetesNamespace, msiInfo.KubernetesNamespaceIgnored, err = m.renderNamespaces(cluster.namespaceTemplate, templateData)
+			}
+			if err != nil {
+				m.Logger.Errorf("failed to generate Kubernetes namespace name for Azure MSI %v in cluster \"%s\": %v", to.String(msiInfo.AzureResourceId), cluster.name, err)
+				continue
+			}
+		}
+
+		ret = append(ret, msiInfo)
+	}
+	m.prometheus.clusterExcluded.WithLabelValues(cluster.name).Set(float64(excluded))
+
+	return ret
+}
+
+// setMsiStatus sets the sync status of an Azure MSI, the status is only tracked for the local cluster
+func (m *MsiOperator) setMsiStatus(cluster *kubernetesCluster, resourceId, namespace, status string) {
+	if cluster.local {
+		m.serviceDiscovery.msi.SetStatus(resourceId, namespace, status)
+	}
+}
+
+// setMsiDenied sets the sync status of an Azure MSI to denied (by policy), the status is only tracked for the local cluster
+func (m *MsiOperator) setMsiDenied(cluster *kubernetesCluster, resourceId, namespace, reason string) {
+	if cluster.local {
+		m.serviceDiscovery.msi.SetDenied(resourceId, namespace, reason)
+	}
+}
+
+// observeCluster updates the metrics of the cluster after an upsert
+func (m *MsiOperator) observeCluster(cluster *kubernetesCluster, duration float64, failed int, err error) {
+	m.prometheus.clusterDuration.WithLabelValues(cluster.name).Set(duration)
+	m.prometheus.clusterErrors.WithLabelValues(cluster.name).Add(float64(failed))
+	if err != nil {
+		m.prometheus.clusterUp.WithLabelValues(cluster.name).Set(0)
+		return
+	}
+	m.prometheus.clusterUp.WithLabelValues(cluster.name).Set(1)
+	m.prometheus.clusterLastSync.WithLabelValues(cluster.name).SetToCurrentTime()
+}
+
+func containsCluster(clusters []*kubernetesCluster, name string) bool {
+	for _, cluster := range clusters {
+		if cluster.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// contextWithCluster returns a context containing the name of the target cluster (used for audit records and notifications)
+func contextWithCluster(ctx context.Context, cluster *kubernetesCluster) context.Context {
+	return context.WithValue(ctx, clusterContextKey{}, cluster.name)
+}
+
+// clusterFromContext returns the name of the target cluster (empty if unknown)
+func clusterFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(clusterContextKey{}).(string); ok {
+		return name
+	}
+	return ""
+}
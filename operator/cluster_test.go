@@ -0,0 +1,143 @@
+package operator
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+  - name: remote
+    cluster:
+      server: https://remote.example.invalid
+contexts:
+  - name: remote
+    context:
+      cluster: remote
+      user: remote
+current-context: remote
+users:
+  - name: remote
+    user:
+      token: secret
+`
+
+var testSecretGvr = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "secrets"}
+
+// testKubeconfigSecret returns a kubeconfig secret in the operator namespace, data values are base64 encoded
+func testKubeconfigSecret(name, resourceVersion string, data map[string]string) *unstructured.Unstructured {
+	encoded := map[string]interface{}{}
+	for key, val := range data {
+		encoded[key] = base64.StdEncoding.EncodeToString([]byte(val))
+	}
+
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":            name,
+				"namespace":       "kube-system",
+				"resourceVersion": resourceVersion,
+				"labels":          map[string]interface{}{"msi.azure.k8s.io/cluster": "true"},
+			},
+			"data": encoded,
+		},
+	}
+}
+
+func newClusterTestOperator(objects ...runtime.Object) *MsiOperator {
+	m := newTestOperator(objects...)
+	m.Conf.Instance.Namespace = to.StringPtr("kube-system")
+	m.Conf.MultiCluster.SecretSelector = "msi.azure.k8s.io/cluster"
+	return m
+}
+
+func TestLoadRemoteClusters(t *testing.T) {
+	invalidBase64 := testKubeconfigSecret("invalid-base64", "1", nil)
+	invalidBase64.Object["data"] = map[string]interface{}{ClusterSecretKeyKubeconfig: "%%%"}
+	unlabeled := testKubeconfigSecret("unlabeled", "1", map[string]string{ClusterSecretKeyKubeconfig: testKubeconfig})
+	unlabeled.SetLabels(nil)
+
+	m := newClusterTestOperator(
+		testKubeconfigSecret("Prod-WEU", "1", map[string]string{ClusterSecretKeyKubeconfig: testKubeconfig}),
+		testKubeconfigSecret("prod-neu-secret", "1", map[string]string{ClusterSecretKeyKubeconfig: testKubeconfig, ClusterSecretKeyName: " Prod-NEU ", ClusterSecretKeyNamespaceTemplate: "{{ .Name }}"}),
+		testKubeconfigSecret("missing-kubeconfig", "1", map[string]string{ClusterSecretKeyName: "missing"}),
+		testKubeconfigSecret("empty-kubeconfig", "1", map[string]string{ClusterSecretKeyKubeconfig: ""}),
+		testKubeconfigSecret("invalid-kubeconfig", "1", map[string]string{ClusterSecretKeyKubeconfig: "clusters: ["}),
+		testKubeconfigSecret("invalid-template", "1", map[string]string{ClusterSecretKeyKubeconfig: testKubeconfig, ClusterSecretKeyNamespaceTemplate: "{{ .Name"}),
+		invalidBase64,
+		unlabeled,
+	)
+
+	m.history.begin(SyncRunTriggerManual)
+	if err := m.loadRemoteClusters(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	run := m.history.end(nil, 0)
+
+	// invalid secrets are skipped and reported, unlabeled secrets are ignored
+	if len(m.kubernetes.remoteClusters) != 2 {
+		t.Fatalf("expected 2 remote clusters, got %v", m.kubernetes.remoteClusters)
+	}
+	if len(run.ErrorMessages) != 5 {
+		t.Errorf("expected 5 invalid kubeconfig secrets, got %v", run.ErrorMessages)
+	}
+	for _, secretName := range []string{"missing-kubeconfig", "empty-kubeconfig", "invalid-kubeconfig", "invalid-template", "invalid-base64"} {
+		found := false
+		for _, msg := range run.ErrorMessages {
+			if strings.Contains(msg, "\""+secretName+"\"") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected error for kubeconfig secret %s, got %v", secretName, run.ErrorMessages)
+		}
+	}
+
+	// name defaults to secret name
+	weu := m.kubernetes.remoteClusters["Prod-WEU"]
+	if weu == nil || weu.name != "prod-weu" || weu.namespaceTemplate != nil || weu.local {
+		t.Errorf("expected cluster prod-weu named by secret, got %+v", weu)
+	}
+	neu := m.kubernetes.remoteClusters["prod-neu-secret"]
+	if neu == nil || neu.name != "prod-neu" || neu.namespaceTemplate == nil {
+		t.Errorf("expected cluster prod-neu named by secret data with namespace template, got %+v", neu)
+	}
+
+	// unchanged secrets keep their client, changed secrets are reloaded
+	changed := testKubeconfigSecret("prod-neu-secret", "2", map[string]string{ClusterSecretKeyKubeconfig: testKubeconfig, ClusterSecretKeyName: "prod-neu-2"})
+	if _, err := m.kubernetes.client.Resource(testSecretGvr).Namespace("kube-system").Update(context.Background(), changed, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.loadRemoteClusters(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.kubernetes.remoteClusters["Prod-WEU"] != weu {
+		t.Errorf("expected unchanged cluster to be kept")
+	}
+	if cluster := m.kubernetes.remoteClusters["prod-neu-secret"]; cluster == nil || cluster.name != "prod-neu-2" {
+		t.Errorf("expected changed cluster to be reloaded, got %+v", cluster)
+	}
+
+	// clusters of deleted secrets are dropped
+	if err := m.kubernetes.client.Resource(testSecretGvr).Namespace("kube-system").Delete(context.Background(), "Prod-WEU", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.loadRemoteClusters(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := m.kubernetes.remoteClusters["Prod-WEU"]; exists || len(m.kubernetes.remoteClusters) != 1 {
+		t.Errorf("expected cluster of deleted secret to be dropped, got %v", m.kubernetes.remoteClusters)
+	}
+}
@@ -0,0 +1,327 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/msi/mgmt/msi"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	ApiPathEventGrid = "/api/v1/eventgrid"
+
+	EventGridEventResourceWriteSuccess  = "Microsoft.Resources.ResourceWriteSuccess"
+	EventGridEventResourceDeleteSuccess = "Microsoft.Resources.ResourceDeleteSuccess"
+
+	eventGridMaxRequestSize = 1024 * 1024
+)
+
+type (
+	// eventGridQueue contains the Azure MSIs (resource id) which need to be refreshed
+	eventGridQueue struct {
+		lock sync.Mutex
+
+		// pending resource ids, true if a delete event was received (deletes are confirmed by fetching the Azure MSI)
+		pending map[string]bool
+		notify  chan struct{}
+	}
+
+	// eventGridCloudEvent is an Event Grid event using CloudEvents v1.0 schema
+	eventGridCloudEvent struct {
+		SpecVersion string `json:"specversion"`
+		Id          string `json:"id"`
+		Type        string `json:"type"`
+		Source      string `json:"source"`
+		Subject     string `json:"subject"`
+		Data        struct {
+			ResourceUri   string `json:"resourceUri"`
+			OperationName string `json:"operationName"`
+			Status        string `json:"status"`
+		} `json:"data"`
+	}
+
+	ApiEventGridResponse struct {
+		Received int `json:"received"`
+		Queued   int `json:"queued"`
+	}
+)
+
+var (
+	errAzureMsiNotFound = errors.New("identity not found")
+)
+
+// initEventGrid validates the Event Grid receiver settings
+func (m *MsiOperator) initEventGrid() {
+	m.eventGrid = newEventGridQueue()
+
+	if m.Conf.EventGrid.Enabled && m.Conf.EventGrid.Token == "" {
+		m.Logger.Panic("Event Grid receiver requires token (--eventgrid.token)")
+	}
+}
+
+func newEventGridQueue() *eventGridQueue {
+	return &eventGridQueue{
+		pending: map[string]bool{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// HandleEventGrid receives Event Grid events (CloudEvents schema) for Azure MSIs and queues a refresh of the affected MSIs, requires token
+func (m *MsiOperator) HandleEventGrid(w http.ResponseWriter, r *http.Request) {
+	if m.Conf.EventGrid.Token == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(m.Conf.EventGrid.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		// CloudEvents webhook validation handshake (abuse protection)
+		origin := r.Header.Get("WebHook-Request-Origin")
+		if origin == "" {
+			http.Error(w, "missing WebHook-Request-Origin header", http.StatusBadRequest)
+			return
+		}
+
+		m.Logger.Infof("accepted Event Grid subscription validation from %v", origin)
+		w.Header().Set("WebHook-Allowed-Origin", origin)
+		w.Header().Set("WebHook-Allowed-Rate", "*")
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !m.health.isLeader() {
+		// Event Grid retries the delivery
+		http.Error(w, ErrSyncNotLeader.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	events, err := parseEventGridCloudEvents(http.MaxBytesReader(w, r.Body, eventGridMaxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queued := 0
+	for _, event := range events {
+		if m.queueEventGridEvent(event) {
+			queued++
+		}
+	}
+
+	m.writeApiResponse(w, ApiEventGridResponse{
+		Received: len(events),
+		Queued:   queued,
+	})
+}
+
+// parseEventGridCloudEvents parses a single CloudEvent or a batch (json array) of CloudEvents
+func parseEventGridCloudEvents(body io.Reader) ([]eventGridCloudEvent, error) {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request: %w", err)
+	}
+
+	content = bytes.TrimSpace(content)
+	events := []eventGridCloudEvent{}
+	if bytes.HasPrefix(content, []byte("[")) {
+		err = json.Unmarshal(content, &events)
+	} else {
+		event := eventGridCloudEvent{}
+		err = json.Unmarshal(content, &event)
+		events = append(events, event)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode CloudEvent: %w", err)
+	}
+
+	return events, nil
+}
+
+// queueEventGridEvent queues the refresh of the Azure MSI, returns false if the event is not relevant
+func (m *MsiOperator) queueEventGridEvent(event eventGridCloudEvent) bool {
+	var deleted bool
+	switch event.Type {
+	case EventGridEventResourceWriteSuccess:
+		deleted = false
+	case EventGridEventResourceDeleteSuccess:
+		deleted = true
+	default:
+		return false
+	}
+
+	resourceId := event.Data.ResourceUri
+	if resourceId == "" {
+		resourceId = event.Subject
+	}
+	resourceId = strings.ToLower(resourceId)
+
+	subscriptionId, _, _, ok := parseUserAssignedIdentityId(resourceId)
+	if !ok {
+		// not an Azure MSI (or sub resource of an Azure MSI)
+		return false
+	}
+
+	if !m.isAzureSubscriptionEnabled(subscriptionId) {
+		m.Logger.Debugf("ignoring Event Grid event %v for Azure MSI %v: subscription is not maintained", event.Id, resourceId)
+		return false
+	}
+
+	m.Logger.Infof("received Event Grid event %v (%v) for Azure MSI %v", event.Id, event.Type, resourceId)
+
+	q := m.eventGrid
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending[resourceId] = deleted
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+		// worker already notified
+	}
+
+	return true
+}
+
+func (m *MsiOperator) startEventGridWorker(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.eventGrid.notify:
+			}
+
+			q := m.eventGrid
+			q.lock.Lock()
+			pending := q.pending
+			q.pending = map[string]bool{}
+			q.lock.Unlock()
+
+			resourceIds := []string{}
+			for resourceId := range pending {
+				resourceIds = append(resourceIds, resourceId)
+			}
+			sort.Strings(resourceIds)
+
+			err := m.run(ctx, SyncRunTriggerEventGrid, func(ctx context.Context) error {
+				var ret error
+				for _, resourceId := range resourceIds {
+					if err := m.refreshAzureMsi(ctx, resourceId, pending[resourceId]); err != nil {
+						m.Logger.Errorf("failed to refresh Azure MSI %v: %v", resourceId, err)
+						ret = err
+					}
+				}
+				return ret
+			})
+			if err != nil {
+				m.Logger.Warnf("Event Grid triggered sync failed: %v", err)
+			}
+		}
+	}()
+}
+
+// refreshAzureMsi fetches a single Azure MSI, updates the snapshot and upserts the affected namespaces
+// events are only hints, the Azure MSI is only removed from the snapshot if Azure reports it as not found
+func (m *MsiOperator) refreshAzureMsi(ctx context.Context, resourceId string, deleteEvent bool) (err error) {
+	subscriptionId, _, _, _ := parseUserAssignedIdentityId(resourceId)
+	ctx, span := m.startSpan(
+		ctx, "refresh azure msi",
+		TraceAttrAzureSubscriptionId.String(subscriptionId),
+		TraceAttrAzureResourceId.String(resourceId),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	var diff *MsiResourceListDiff
+
+	msiResource, err := m.fetchAzureMsi(ctx, resourceId)
+	switch {
+	case errors.Is(err, errAzureMsiNotFound):
+		diff = m.serviceDiscovery.msi.Remove(resourceId)
+	case err != nil:
+		return err
+	default:
+		if deleteEvent {
+			m.Logger.Warnf("ignoring Event Grid delete event for Azure MSI %v: Azure MSI still exists", resourceId)
+		}
+
+		msiInfo, err := m.generateMsiKubernetesResourceInfo(msiResource)
+		if err != nil {
+			return err
+		}
+		diff = m.serviceDiscovery.msi.Update(msiInfo)
+	}
+
+	m.observeSnapshot(diff)
+	return m.reconcileChanges(ctx, diff)
+}
+
+// fetchAzureMsi fetches a single Azure MSI by resource id
+func (m *MsiOperator) fetchAzureMsi(ctx context.Context, resourceId string) (*msi.Identity, error) {
+	subscriptionId, resourceGroup, name, ok := parseUserAssignedIdentityId(resourceId)
+	if !ok {
+		return nil, fmt.Errorf("invalid Azure MSI resource id \"%s\"", resourceId)
+	}
+
+	client := msi.NewUserAssignedIdentitiesClientWithBaseURI(m.azure.environment.ResourceManagerEndpoint, subscriptionId)
+	m.decorateAzureClient(&client.Client)
+
+	result, err := client.Get(ctx, resourceGroup, name)
+	if err != nil {
+		if result.Response.Response != nil && result.StatusCode == http.StatusNotFound {
+			return nil, errAzureMsiNotFound
+		}
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// isAzureSubscriptionEnabled returns true if the subscription is maintained by the operator
+func (m *MsiOperator) isAzureSubscriptionEnabled(subscriptionId string) bool {
+	for _, subscription := range m.azure.subscriptionList {
+		if strings.EqualFold(to.String(subscription.SubscriptionID), subscriptionId) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUserAssignedIdentityId parses the resource id of an Azure MSI
+// (/subscriptions/xxx/resourceGroups/xxx/providers/Microsoft.ManagedIdentity/userAssignedIdentities/xxx)
+func parseUserAssignedIdentityId(resourceId string) (subscriptionId, resourceGroup, name string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(resourceId, "/"), "/")
+	if len(parts) != 9 || parts[0] != "" {
+		return
+	}
+
+	if !strings.EqualFold(parts[1], "subscriptions") ||
+		!strings.EqualFold(parts[3], "resourceGroups") ||
+		!strings.EqualFold(parts[5], "providers") ||
+		!strings.EqualFold(parts[6], "Microsoft.ManagedIdentity") ||
+		!strings.EqualFold(parts[7], "userAssignedIdentities") {
+		return
+	}
+
+	if parts[2] == "" || parts[4] == "" || parts[8] == "" {
+		return
+	}
+
+	return parts[2], parts[4], parts[8], true
+}
@@ -0,0 +1,158 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+)
+
+func newEventGridTestOperator() *MsiOperator {
+	m := &MsiOperator{
+		Logger:    zap.NewNop().Sugar(),
+		health:    newHealthStatus(),
+		eventGrid: newEventGridQueue(),
+	}
+	m.Conf.EventGrid.Token = "secret"
+	m.azure.subscriptionList = []subscriptions.Subscription{
+		{SubscriptionID: to.StringPtr("00000000-0000-0000-0000-000000000001")},
+	}
+	m.health.setLeader(true)
+	return m
+}
+
+func postEventGridTestFile(t *testing.T, m *MsiOperator, method, url, file string) *httptest.ResponseRecorder {
+	body := []byte{}
+	if file != "" {
+		var err error
+		if body, err = os.ReadFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set("WebHook-Request-Origin", "eventgrid.azure.net")
+
+	w := httptest.NewRecorder()
+	m.HandleEventGrid(w, req)
+	return w
+}
+
+func TestEventGridValidation(t *testing.T) {
+	m := newEventGridTestOperator()
+
+	w := postEventGridTestFile(t, m, http.MethodOptions, ApiPathEventGrid+"?token=secret", "")
+	if w.Code != http.StatusOK || w.Header().Get("WebHook-Allowed-Origin") != "eventgrid.azure.net" {
+		t.Errorf("validation: unexpected response %v %v", w.Code, w.Header())
+	}
+
+	w = postEventGridTestFile(t, m, http.MethodOptions, ApiPathEventGrid+"?token=wrong", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("validation with wrong token: expected %v, got %v", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestEventGridEvents(t *testing.T) {
+	m := newEventGridTestOperator()
+
+	w := postEventGridTestFile(t, m, http.MethodPost, ApiPathEventGrid+"?token=secret", "testdata/eventgrid-write.json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("single event: unexpected response %v: %v", w.Code, w.Body.String())
+	}
+
+	w = postEventGridTestFile(t, m, http.MethodPost, ApiPathEventGrid+"?token=secret", "testdata/eventgrid-batch.json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch: unexpected response %v: %v", w.Code, w.Body.String())
+	}
+
+	expected := map[string]bool{
+		"/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups/example-rg/providers/microsoft.managedidentity/userassignedidentities/example-msi": false,
+		"/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups/example-rg/providers/microsoft.managedidentity/userassignedidentities/deleted-msi": true,
+	}
+	if !reflect.DeepEqual(m.eventGrid.pending, expected) {
+		t.Errorf("expected pending %v, got %v", expected, m.eventGrid.pending)
+	}
+
+	// not leader
+	m.health.setLeader(false)
+	w = postEventGridTestFile(t, m, http.MethodPost, ApiPathEventGrid+"?token=secret", "testdata/eventgrid-write.json")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("not leader: expected %v, got %v", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestEventGridRequiresToken(t *testing.T) {
+	m := newEventGridTestOperator()
+	m.Conf.EventGrid.Token = ""
+
+	w := postEventGridTestFile(t, m, http.MethodPost, ApiPathEventGrid+"?token=", "testdata/eventgrid-write.json")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: expected %v, got %v", http.StatusUnauthorized, w.Code)
+	}
+	if len(m.eventGrid.pending) != 0 {
+		t.Errorf("without token: expected no pending events, got %v", m.eventGrid.pending)
+	}
+}
+
+func TestEventGridRefreshConfirmsDeletes(t *testing.T) {
+	resourceId := "/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups/example-rg/providers/microsoft.managedidentity/userassignedidentities/example-msi"
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"id": "` + resourceId + `", "name": "example-msi", "location": "westeurope", "properties": {"tenantId": "00000000-0000-0000-0000-00000000000a", "principalId": "00000000-0000-0000-0000-00000000000b", "clientId": "00000000-0000-0000-0000-00000000000c"}}`))
+		}
+	}))
+	defer server.Close()
+
+	m := newTestOperator()
+	m.azure.environment.ResourceManagerEndpoint = server.URL
+	emptyTemplate := template.Must(template.New("").Parse(""))
+	m.msi.resourceNameTemplate = emptyTemplate
+	m.msi.namespaceTemplate = emptyTemplate
+	m.msi.bindingSelectorTemplate = emptyTemplate
+	m.msi.clusterTemplate = emptyTemplate
+	m.serviceDiscovery.msi.Update(MsiResourceInfo{AzureResourceId: to.StringPtr(resourceId)})
+
+	exists := func() bool {
+		return len(m.serviceDiscovery.msi.Find(func(msiInfo MsiResourceInfo) bool {
+			return to.String(msiInfo.AzureResourceId) == resourceId
+		})) == 1
+	}
+
+	// delete event of existing Azure MSI is ignored
+	if err := m.refreshAzureMsi(context.Background(), resourceId, true); err != nil {
+		t.Fatal(err)
+	}
+	if !exists() {
+		t.Errorf("expected Azure MSI to be kept after unconfirmed delete event")
+	}
+
+	// failed lookup doesn't remove the Azure MSI
+	status = http.StatusForbidden
+	if err := m.refreshAzureMsi(context.Background(), resourceId, true); err == nil {
+		t.Errorf("expected error for failed lookup")
+	}
+	if !exists() {
+		t.Errorf("expected Azure MSI to be kept after failed lookup")
+	}
+
+	status = http.StatusNotFound
+	if err := m.refreshAzureMsi(context.Background(), resourceId, true); err != nil {
+		t.Fatal(err)
+	}
+	if exists() {
+		t.Errorf("expected Azure MSI to be removed after confirmed delete")
+	}
+}
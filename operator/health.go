@@ -0,0 +1,149 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	healthStatus struct {
+		lock sync.RWMutex
+
+		// time when operator started (or aquired leadership)
+		startTime time.Time
+
+		leader       bool
+		lastRun      time.Time
+		lastSuccess  time.Time
+		lastError    string
+		watchFailing map[string]bool
+	}
+)
+
+func newHealthStatus() *healthStatus {
+	return &healthStatus{
+		startTime:    time.Now(),
+		watchFailing: map[string]bool{},
+	}
+}
+
+func (h *healthStatus) setLeader(leader bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.leader = leader
+	h.startTime = time.Now()
+}
+
+func (h *healthStatus) isLeader() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.leader
+}
+
+func (h *healthStatus) setRunResult(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lastRun = time.Now()
+	if err != nil {
+		h.lastError = err.Error()
+	} else {
+		h.lastSuccess = h.lastRun
+		h.lastError = ""
+	}
+}
+
+func (h *healthStatus) setWatchStatus(name string, running bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.watchFailing[name] = !running
+}
+
+// CheckReadiness returns true if the Azure MSI snapshot is loaded (first successful discovery)
+// leadership is not required, all replicas serve admission webhooks, API and Event Grid using their snapshot
+// returns the reason as message
+func (m *MsiOperator) CheckReadiness() (bool, string) {
+	h := m.health
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if m.serviceDiscovery.msi.Version() == 0 {
+		if h.lastError != "" {
+			return false, fmt.Sprintf("waiting for first successful discovery, last error: %s", h.lastError)
+		}
+		return false, "waiting for first successful discovery"
+	}
+
+	if h.lastSuccess.IsZero() {
+		return true, "Ok"
+	}
+	return true, fmt.Sprintf("Ok (last successful sync: %s)", h.lastSuccess.Format(time.RFC3339))
+}
+
+// CheckLeadership returns true if the operator is leader (sync loops are running)
+// returns the reason as message, restarting watches are reported in the message
+func (m *MsiOperator) CheckLeadership() (bool, string) {
+	h := m.health
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if !h.leader {
+		return false, "not leader"
+	}
+
+	failingWatches := []string{}
+	for name, failing := range h.watchFailing {
+		if failing {
+			failingWatches = append(failingWatches, name)
+		}
+	}
+	if len(failingWatches) > 0 {
+		sort.Strings(failingWatches)
+		return true, fmt.Sprintf("Ok (restarting watch: %s)", strings.Join(failingWatches, ", "))
+	}
+
+	return true, "Ok"
+}
+
+// CheckLiveness returns false if no sync run finished within the liveness deadline (sync loop is stuck)
+// failed runs count as progress, restarting the operator doesn't fix Azure or Kubernetes API outages
+// returns the reason as message
+func (m *MsiOperator) CheckLiveness() (bool, string) {
+	h := m.health
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	deadline := m.livenessDeadline()
+	if deadline <= 0 {
+		return true, "Ok"
+	}
+
+	lastProgress := h.lastRun
+	if lastProgress.Before(h.startTime) {
+		lastProgress = h.startTime
+	}
+
+	if since := time.Since(lastProgress); since > deadline {
+		return false, fmt.Sprintf("no finished sync run since %s (deadline %s)", since.Round(time.Second).String(), deadline.String())
+	}
+
+	if h.lastError != "" {
+		return true, fmt.Sprintf("Ok (last error: %s)", h.lastError)
+	}
+	return true, "Ok"
+}
+
+func (m *MsiOperator) livenessDeadline() time.Duration {
+	if m.Conf.Sync.LivenessDeadline != 0 {
+		return m.Conf.Sync.LivenessDeadline
+	}
+
+	// default: three sync (or discovery) intervals
+	interval := m.Conf.Sync.Interval
+	if m.Conf.Sync.DiscoveryInterval > interval {
+		interval = m.Conf.Sync.DiscoveryInterval
+	}
+	return 3 * interval
+}
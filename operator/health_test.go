@@ -0,0 +1,56 @@
+package operator
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckReadiness(t *testing.T) {
+	m := newTestOperator()
+
+	if ok, msg := m.CheckReadiness(); ok {
+		t.Fatalf("expected not ready before first discovery, got %q", msg)
+	}
+
+	// readiness doesn't depend on leadership
+	m.serviceDiscovery.msi.Commit()
+	if ok, msg := m.CheckReadiness(); !ok {
+		t.Fatalf("expected ready after first discovery, got %q", msg)
+	}
+	if ok, _ := m.CheckLeadership(); ok {
+		t.Errorf("expected not leader")
+	}
+
+	m.health.setLeader(true)
+	m.health.setWatchStatus("namespace", false)
+	if ok, msg := m.CheckLeadership(); !ok || msg != "Ok (restarting watch: namespace)" {
+		t.Errorf("expected leader with restarting watch, got %v %q", ok, msg)
+	}
+}
+
+func TestCheckLiveness(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Sync.LivenessDeadline = time.Minute
+
+	if ok, msg := m.CheckLiveness(); !ok {
+		t.Fatalf("expected live after start, got %q", msg)
+	}
+
+	m.health.startTime = time.Now().Add(-2 * time.Minute)
+	if ok, _ := m.CheckLiveness(); ok {
+		t.Fatalf("expected not live without finished run within deadline")
+	}
+
+	// failed runs are progress too
+	m.health.setRunResult(errors.New("azure unavailable"))
+	if ok, msg := m.CheckLiveness(); !ok || msg != "Ok (last error: azure unavailable)" {
+		t.Errorf("expected live after failed run, got %v %q", ok, msg)
+	}
+
+	m.Conf.Sync.LivenessDeadline = -1
+	m.health.lastRun = time.Time{}
+	if ok, _ := m.CheckLiveness(); !ok {
+		t.Errorf("expected disabled liveness check")
+	}
+}
@@ -0,0 +1,270 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	ApiPathRuns = "/api/v1/runs"
+
+	// sync run triggers
+	SyncRunTriggerManual    = "manual"
+	SyncRunTriggerEventGrid = "eventgrid"
+
+	// triggers of upserts by Kubernetes watches (recorded as sync runs, skipped while the upsert lock time is active)
+	SyncRunTriggerWatchNamespace = "watch-namespace"
+	SyncRunTriggerWatchBinding   = "watch-binding"
+
+	// sync results of Kubernetes resources
+	SyncResultCreated   = "created"
+	SyncResultUpdated   = "updated"
+	SyncResultUnchanged = "unchanged"
+	SyncResultError     = "error"
+
+	// max number of error messages per sync run
+	syncRunMaxErrorMessages = 25
+)
+
+type (
+	SyncRun struct {
+		Id              uint64         `json:"id"`
+		Trigger         string         `json:"trigger"`
+		StartTime       time.Time      `json:"startTime"`
+		EndTime         time.Time      `json:"endTime"`
+		Duration        float64        `json:"durationSeconds"`
+		Success         bool           `json:"success"`
+		SnapshotVersion uint64         `json:"snapshotVersion"`
+		Subscriptions   map[string]int `json:"subscriptions"`
+		Created         int            `json:"created"`
+		Updated         int            `json:"updated"`
+		Unchanged       int            `json:"unchanged"`
+		Errors          int            `json:"errors"`
+		ErrorMessages   []string       `json:"errorMessages"`
+	}
+
+	// syncRunHistory is a ring buffer of the last sync runs
+	syncRunHistory struct {
+		lock sync.Mutex
+
+		runs    []SyncRun
+		next    int
+		lastId  uint64
+		current *SyncRun
+	}
+)
+
+func newSyncRunHistory(size int) *syncRunHistory {
+	if size < 1 {
+		size = 1
+	}
+
+	return &syncRunHistory{
+		runs: make([]SyncRun, 0, size),
+	}
+}
+
+// begin starts recording of a sync run
+func (h *syncRunHistory) begin(trigger string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastId++
+	h.current = &SyncRun{
+		Id:            h.lastId,
+		Trigger:       trigger,
+		StartTime:     time.Now(),
+		Subscriptions: map[string]int{},
+		ErrorMessages: []string{},
+	}
+}
+
+// end finishes the recording of the current sync run, adds it to the history and returns it
+func (h *syncRunHistory) end(err error, snapshotVersion uint64) *SyncRun {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	run := h.current
+	if run == nil {
+		return nil
+	}
+	h.current = nil
+
+	run.EndTime = time.Now()
+	run.Duration = run.EndTime.Sub(run.StartTime).Seconds()
+	run.SnapshotVersion = snapshotVersion
+	run.Success = err == nil
+	if err != nil {
+		run.addErrorMessage(err.Error())
+	}
+
+	if len(h.runs) < cap(h.runs) {
+		h.runs = append(h.runs, *run)
+	} else {
+		h.runs[h.next] = *run
+	}
+	h.next = (h.next + 1) % cap(h.runs)
+
+	return run.copy()
+}
+
+// record modifies the current sync run (if any)
+func (h *syncRunHistory) record(fn func(run *SyncRun)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.current != nil {
+		fn(h.current)
+	}
+}
+
+// count counts the sync result of a Kubernetes resource for the current sync run
+func (h *syncRunHistory) count(result string) {
+	h.record(func(run *SyncRun) {
+		switch result {
+		case SyncResultCreated:
+			run.Created++
+		case SyncResultUpdated:
+			run.Updated++
+		case SyncResultUnchanged:
+			run.Unchanged++
+		case SyncResultError:
+			run.Errors++
+		}
+	})
+}
+
+// addError adds an error message to the current sync run
+func (h *syncRunHistory) addError(message string, args ...interface{}) {
+	h.record(func(run *SyncRun) {
+		run.addErrorMessage(fmt.Sprintf(message, args...))
+	})
+}
+
+// setSubscription sets the number of discovered Azure MSIs of a subscription for the current sync run
+func (h *syncRunHistory) setSubscription(subscriptionId string, count int) {
+	h.record(func(run *SyncRun) {
+		run.Subscriptions[subscriptionId] = count
+	})
+}
+
+// list returns the recorded sync runs (newest first)
+func (h *syncRunHistory) list() []SyncRun {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ret := []SyncRun{}
+	for i := 1; i <= len(h.runs); i++ {
+		idx := (h.next - i + cap(h.runs)) % cap(h.runs)
+		ret = append(ret, *h.runs[idx].copy())
+	}
+	return ret
+}
+
+func (r *SyncRun) addErrorMessage(message string) {
+	if len(r.ErrorMessages) < syncRunMaxErrorMessages {
+		r.ErrorMessages = append(r.ErrorMessages, message)
+	}
+}
+
+func (r *SyncRun) copy() *SyncRun {
+	ret := *r
+	ret.Subscriptions = map[string]int{}
+	for key, val := range r.Subscriptions {
+		ret.Subscriptions[key] = val
+	}
+	ret.ErrorMessages = append([]string{}, r.ErrorMessages...)
+	return &ret
+}
+
+// HandleApiRuns lists the last sync runs (newest first)
+func (m *MsiOperator) HandleApiRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.writeApiResponse(w, m.history.list())
+}
+
+// writeHistoryConfigMap writes the summary of the last sync runs to a ConfigMap in the operator namespace
+func (m *MsiOperator) writeHistoryConfigMap(ctx context.Context, lastRun *SyncRun) {
+	if m.Conf.History.ConfigMap == "" || m.Conf.Instance.Namespace == nil || *m.Conf.Instance.Namespace == "" {
+		return
+	}
+
+	namespace := *m.Conf.Instance.Namespace
+	name := m.Conf.History.ConfigMap
+	contextLogger := m.Logger.With(zap.String("k8sNamespace", namespace), zap.String("k8sResource", name))
+
+	lastRunJson, err := json.MarshalIndent(lastRun, "", "  ")
+	if err != nil {
+		contextLogger.Error(err)
+		return
+	}
+
+	historyJson, err := json.MarshalIndent(m.history.list(), "", "  ")
+	if err != nil {
+		contextLogger.Error(err)
+		return
+	}
+
+	status := "Succeeded"
+	if !lastRun.Success {
+		status = "Failed"
+	}
+
+	data := map[string]interface{}{
+		"lastRun.id":      fmt.Sprintf("%d", lastRun.Id),
+		"lastRun.trigger": lastRun.Trigger,
+		"lastRun.status":  status,
+		"lastRun.time":    lastRun.EndTime.Format(time.RFC3339),
+		"lastRun.summary": fmt.Sprintf("created: %d, updated: %d, unchanged: %d, errors: %d", lastRun.Created, lastRun.Updated, lastRun.Unchanged, lastRun.Errors),
+		"lastRun.json":    string(lastRunJson),
+		"history.json":    string(historyJson),
+	}
+
+	gvr := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "configmaps"}
+	configMap, err := m.kubernetes.client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if err := unstructured.SetNestedField(configMap.Object, data, "data"); err != nil {
+			contextLogger.Error(err)
+			return
+		}
+
+		if _, err := m.kubernetes.client.Resource(gvr).Namespace(namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+			contextLogger.Errorf("failed to update ConfigMap \"%s/%s\": %v", namespace, name, err)
+		}
+	case errors.IsNotFound(err):
+		configMap = &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name": name,
+					"labels": map[string]interface{}{
+						K8sLabelManagedBy: K8sManagedByValue,
+					},
+				},
+				"data": data,
+			},
+		}
+
+		if _, err := m.kubernetes.client.Resource(gvr).Namespace(namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+			contextLogger.Errorf("failed to create ConfigMap \"%s/%s\": %v", namespace, name, err)
+		}
+	default:
+		contextLogger.Errorf("failed to fetch ConfigMap \"%s/%s\": %v", namespace, name, err)
+	}
+}
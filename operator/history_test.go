@@ -0,0 +1,49 @@
+package operator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyncRunHistory(t *testing.T) {
+	history := newSyncRunHistory(3)
+
+	for i := 0; i < 5; i++ {
+		history.begin(SyncScheduleReconcile)
+		history.count(SyncResultCreated)
+		history.count(SyncResultUnchanged)
+		history.count(SyncResultUnchanged)
+		history.setSubscription("xxx", i)
+
+		var err error
+		if i == 4 {
+			history.addError("resource %d failed", i)
+			err = errors.New("sync failed")
+		}
+		history.end(err, uint64(i))
+	}
+
+	// not recorded without running sync
+	history.count(SyncResultCreated)
+
+	runs := history.list()
+	if len(runs) != 3 {
+		t.Fatalf("expected 3 runs, got %d", len(runs))
+	}
+
+	for i, run := range runs {
+		if expected := uint64(5 - i); run.Id != expected {
+			t.Errorf("run %d: expected id %d, got %d", i, expected, run.Id)
+		}
+		if run.Created != 1 || run.Unchanged != 2 || run.Subscriptions["xxx"] != int(run.Id-1) {
+			t.Errorf("run %d: unexpected counts %+v", i, run)
+		}
+	}
+
+	if runs[0].Success || len(runs[0].ErrorMessages) != 2 {
+		t.Errorf("expected failed run with 2 error messages, got %+v", runs[0])
+	}
+	if !runs[1].Success || len(runs[1].ErrorMessages) != 0 {
+		t.Errorf("expected successful run, got %+v", runs[1])
+	}
+}
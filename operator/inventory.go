@@ -0,0 +1,267 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// skip reasons of Azure MSIs
+	MsiSkipReasonNoNamespace         = "NoNamespace"
+	MsiSkipReasonNamespaceNotAllowed = "NamespaceNotAllowed"
+	MsiSkipReasonNoResourceName      = "NoResourceName"
+	MsiSkipReasonClusterExcluded     = "ClusterExcluded"
+	MsiSkipReasonSystemAssigned      = "SystemAssigned"
+
+	// sync status of Azure MSIs which were not synced yet
+	MsiSyncStatusPending = "Pending"
+	MsiSyncStatusSkipped = "Skipped"
+
+	// min interval of the (cluster-wide) AzureIdentity list for inventory metrics
+	inventoryAzureIdentityListInterval = 5 * time.Minute
+)
+
+type (
+	// constGaugeVec is a gauge vector (prometheus.Collector) whose series are replaced at once,
+	// unlike GaugeVec.Reset() scrapes never see missing series while the new values are collected
+	constGaugeVec struct {
+		desc   *prometheus.Desc
+		labels int
+
+		lock    sync.RWMutex
+		metrics []prometheus.Metric
+	}
+
+	// constGaugeValues contains the new series of a constGaugeVec
+	constGaugeValues struct {
+		labels int
+		series map[string]*constGaugeSeries
+	}
+
+	constGaugeSeries struct {
+		labelValues []string
+		value       float64
+	}
+)
+
+// msiSkipReason returns the reason why an Azure MSI is not synced to any namespace of the cluster (empty if not skipped)
+func msiSkipReason(msiInfo MsiResourceInfo, cluster string) string {
+	switch {
+	case msiInfo.SyncDisabled:
+		return MsiSkipReasonSystemAssigned
+	case !msiInfo.TargetsCluster(cluster):
+		return MsiSkipReasonClusterExcluded
+	case len(msiInfo.KubernetesNamespace) == 0 && len(msiInfo.KubernetesNamespaceIgnored) > 0:
+		return MsiSkipReasonNamespaceNotAllowed
+	case len(msiInfo.KubernetesNamespace) == 0:
+		return MsiSkipReasonNoNamespace
+	case msiInfo.KubernetesResourceName == nil:
+		return MsiSkipReasonNoResourceName
+	}
+	return ""
+}
+
+// updateInventoryMetrics updates the inventory metrics of the current snapshot and the managed Kubernetes resources
+// series are replaced at once so series of removed Azure MSIs and namespaces disappear without empty scrapes
+func (m *MsiOperator) updateInventoryMetrics(ctx context.Context) {
+	if m.serviceDiscovery.msi.Version() == 0 {
+		// no discovery yet
+		return
+	}
+
+	msiList := m.serviceDiscovery.msi.GetList()
+
+	// Azure MSIs
+	subscriptionResources := m.prometheus.subscriptionResources.newValues()
+	for _, subscription := range m.azure.subscriptionList {
+		subscriptionResources.add(0, to.String(subscription.SubscriptionID))
+	}
+
+	resourcesSkipped := m.prometheus.resourcesSkipped.newValues()
+	for _, reason := range []string{
+		MsiSkipReasonNoNamespace,
+		MsiSkipReasonNamespaceNotAllowed,
+		MsiSkipReasonNoResourceName,
+		MsiSkipReasonClusterExcluded,
+		MsiSkipReasonSystemAssigned,
+		MsiSyncStatusNamespaceMissing,
+		MsiSyncStatusDenied,
+	} {
+		resourcesSkipped.add(0, reason)
+	}
+
+	msiResources := m.prometheus.msiResource.newValues()
+	highPrivilege := m.prometheus.highPrivilege.newValues()
+	for _, msiInfo := range msiList {
+		subscriptionId := to.String(msiInfo.AzureSubscriptionId)
+		resourceGroup := to.String(msiInfo.AzureResourceGroup)
+		name := to.String(msiInfo.AzureResourceName)
+		subscriptionResources.add(1, subscriptionId)
+
+		clientId := ""
+		if msiInfo.Resource != nil && msiInfo.Resource.UserAssignedIdentityProperties != nil {
+			clientId = msiInfo.Resource.ClientID.String()
+		}
+
+		for _, roleAssignment := range highPrivilegeRoleAssignments(msiInfo.RoleAssignments, m.Conf.Azure.RoleAssignments.HighPrivilege) {
+			highPrivilege.set(1, subscriptionId, resourceGroup, name, clientId, roleAssignment.RoleName, roleAssignment.Scope)
+		}
+
+		if reason := msiSkipReason(msiInfo, m.kubernetes.cluster.name); reason != "" {
+			resourcesSkipped.add(1, reason)
+			msiResources.set(1, subscriptionId, resourceGroup, name, clientId, "", MsiSyncStatusSkipped)
+			continue
+		}
+
+		status := m.serviceDiscovery.msi.GetStatus(to.String(msiInfo.AzureResourceId))
+		namespaceMissing := false
+		denied := false
+		for _, namespace := range msiInfo.KubernetesNamespace {
+			namespaceStatus := MsiSyncStatusPending
+			if val, exists := status[namespace]; exists {
+				namespaceStatus = val
+			}
+
+			if namespaceStatus == MsiSyncStatusNamespaceMissing {
+				namespaceMissing = true
+			}
+
+			if namespaceStatus == MsiSyncStatusDenied {
+				denied = true
+			}
+
+			msiResources.set(1, subscriptionId, resourceGroup, name, clientId, namespace, namespaceStatus)
+		}
+
+		if namespaceMissing {
+			resourcesSkipped.add(1, MsiSyncStatusNamespaceMissing)
+		}
+
+		if denied {
+			resourcesSkipped.add(1, MsiSyncStatusDenied)
+		}
+	}
+
+	m.prometheus.msiResource.replace(msiResources)
+	m.prometheus.highPrivilege.replace(highPrivilege)
+	m.prometheus.subscriptionResources.replace(subscriptionResources)
+	m.prometheus.resourcesSkipped.replace(resourcesSkipped)
+
+	// managed AzureIdentities, listed cluster-wide so the list is throttled (eg. for Event Grid triggered runs)
+	if time.Since(m.inventory.azureIdentitiesListed) < inventoryAzureIdentityListInterval {
+		return
+	}
+
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
+	list, err := m.kubernetes.client.Resource(gvr).List(ctx, metav1.ListOptions{
+		LabelSelector: K8sLabelManagedBy + "=" + K8sManagedByValue,
+	})
+	if err != nil {
+		m.Logger.Warnf("unable to list AzureIdentities for inventory metrics: %v", err)
+		return
+	}
+	m.inventory.azureIdentitiesListed = time.Now()
+
+	// expected AzureIdentities (alias/namespace/name)
+	expected := map[string]bool{}
+	for _, msiInfo := range msiList {
+		if msiSkipReason(msiInfo, m.kubernetes.cluster.name) != "" {
+			continue
+		}
+
+		alias := msiResourceAlias(to.String(msiInfo.AzureResourceId))
+		for _, namespace := range msiInfo.KubernetesNamespace {
+			expected[alias+"/"+namespace+"/"+*msiInfo.KubernetesResourceName] = true
+		}
+	}
+
+	azureIdentities := m.prometheus.azureIdentities.newValues()
+	azureIdentitiesOrphans := m.prometheus.azureIdentitiesOrphans.newValues()
+	for _, item := range list.Items {
+		namespace := item.GetNamespace()
+		azureIdentities.add(1, namespace)
+		azureIdentitiesOrphans.add(0, namespace)
+
+		alias := item.GetLabels()[m.labelName("alias")]
+		if !expected[alias+"/"+namespace+"/"+item.GetName()] {
+			azureIdentitiesOrphans.add(1, namespace)
+		}
+	}
+
+	m.prometheus.azureIdentities.replace(azureIdentities)
+	m.prometheus.azureIdentitiesOrphans.replace(azureIdentitiesOrphans)
+}
+
+// newConstGaugeVec returns a gauge vector whose series are replaced at once
+func newConstGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *constGaugeVec {
+	return &constGaugeVec{
+		desc:   prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, labelNames, opts.ConstLabels),
+		labels: len(labelNames),
+	}
+}
+
+// newValues returns an empty set of series for replace
+func (v *constGaugeVec) newValues() *constGaugeValues {
+	return &constGaugeValues{
+		labels: v.labels,
+		series: map[string]*constGaugeSeries{},
+	}
+}
+
+// replace swaps the series, scrapes either get the previous or the new series
+func (v *constGaugeVec) replace(values *constGaugeValues) {
+	metrics := make([]prometheus.Metric, 0, len(values.series))
+	for _, series := range values.series {
+		metrics = append(metrics, prometheus.MustNewConstMetric(v.desc, prometheus.GaugeValue, series.value, series.labelValues...))
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.metrics = metrics
+}
+
+// Describe implements prometheus.Collector
+func (v *constGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements prometheus.Collector
+func (v *constGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	for _, metric := range v.metrics {
+		ch <- metric
+	}
+}
+
+// set sets the value of the series
+func (s *constGaugeValues) set(value float64, labelValues ...string) {
+	s.get(labelValues).value = value
+}
+
+// add adds the value to the series (created with 0 if missing)
+func (s *constGaugeValues) add(value float64, labelValues ...string) {
+	s.get(labelValues).value += value
+}
+
+func (s *constGaugeValues) get(labelValues []string) *constGaugeSeries {
+	if len(labelValues) != s.labels {
+		panic(fmt.Sprintf("expected %d label values, got %d", s.labels, len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	series, exists := s.series[key]
+	if !exists {
+		series = &constGaugeSeries{labelValues: labelValues}
+		s.series[key] = series
+	}
+	return series
+}
@@ -0,0 +1,71 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestConstGaugeVecReplace(t *testing.T) {
+	vec := newConstGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "test"}, []string{"namespace"})
+
+	values := vec.newValues()
+	values.add(1, "team-a")
+	values.add(1, "team-a")
+	values.set(3, "team-b")
+
+	// series are only visible after replace
+	if count := testutil.CollectAndCount(vec); count != 0 {
+		t.Fatalf("expected no series before replace, got %d", count)
+	}
+	vec.replace(values)
+
+	expected := `
+# HELP test_gauge test
+# TYPE test_gauge gauge
+test_gauge{namespace="team-a"} 2
+test_gauge{namespace="team-b"} 3
+`
+	if err := testutil.CollectAndCompare(vec, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// series missing in new values are removed
+	values = vec.newValues()
+	values.set(1, "team-b")
+	vec.replace(values)
+	if count := testutil.CollectAndCount(vec); count != 1 {
+		t.Errorf("expected 1 series after replace, got %d", count)
+	}
+}
+
+func TestUpdateInventoryMetricsThrottlesAzureIdentityList(t *testing.T) {
+	m := newTestOperator(testAzureIdentity("team-a", "foo", map[string]interface{}{K8sLabelManagedBy: K8sManagedByValue}))
+	m.serviceDiscovery.msi.Update(MsiResourceInfo{
+		AzureResourceId:     to.StringPtr("/subscriptions/xxx/resourcegroups/rg/providers/microsoft.managedidentity/userassignedidentities/foo"),
+		AzureSubscriptionId: to.StringPtr("xxx"),
+	})
+	client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+
+	m.updateInventoryMetrics(context.Background())
+	m.updateInventoryMetrics(context.Background())
+
+	lists := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "list" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("expected 1 AzureIdentity list, got %d", lists)
+	}
+
+	if count := testutil.CollectAndCount(m.prometheus.azureIdentitiesOrphans); count != 1 {
+		t.Errorf("expected orphaned AzureIdentity series, got %d", count)
+	}
+}
@@ -0,0 +1,217 @@
+package operator
+
+import (
+	"context"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// max wait time for lease release on shutdown
+	leaderElectionReleaseTimeout = 5 * time.Second
+)
+
+type (
+	leaderContextKey struct{}
+)
+
+// Start starts the operator, sync loops are only running while being leader (if leader election is enabled)
+func (m *MsiOperator) Start(syncInterval time.Duration) {
+	if !m.Conf.Lease.Enabled {
+		// API calls of runs are cancelled after the shutdown timeout
+		go m.startLeading(m.ctx, syncInterval)
+		return
+	}
+
+	m.leaderElection.ctx, m.leaderElection.cancel = context.WithCancel(context.Background())
+	m.leaderElection.done = make(chan struct{})
+	go m.runLeaderElection(syncInterval)
+
+	m.startStandbyDiscovery(m.discoveryInterval(syncInterval))
+}
+
+// startStandbyDiscovery keeps the Azure MSI snapshot of non-leaders up to date (used by admission webhooks and API)
+// discovery is read-only, Kubernetes resources are only written by the leader
+func (m *MsiOperator) startStandbyDiscovery(interval time.Duration) {
+	schedule := m.newSyncScheduler(SyncScheduleStandby, interval)
+
+	go func() {
+		for {
+			var err error
+			if !m.health.isLeader() {
+				err = m.standbyDiscover()
+			}
+			delay := schedule.next(err)
+
+			select {
+			case <-m.stopCtx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+// standbyDiscover runs the Azure MSI servicediscovery without reconciliation, skipped if leader or a sync is running
+func (m *MsiOperator) standbyDiscover() error {
+	if !m.runLock.TryAcquire(1) {
+		return nil
+	}
+	defer m.runLock.Release(1)
+
+	if m.stopCtx.Err() != nil || m.health.isLeader() {
+		return nil
+	}
+
+	_, err := m.discover(contextWithSyncTrigger(m.ctx, SyncScheduleStandby))
+	m.health.setRunResult(err)
+	return err
+}
+
+func (m *MsiOperator) runLeaderElection(syncInterval time.Duration) {
+	defer close(m.leaderElection.done)
+
+	if m.Conf.Instance.Namespace == nil || *m.Conf.Instance.Namespace == "" {
+		m.Logger.Panic("leader election requires instance namespace (--instance.namespace)")
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+	if m.Conf.Instance.Pod != nil && *m.Conf.Instance.Pod != "" {
+		identity = *m.Conf.Instance.Pod
+	}
+
+	clientset, err := kubernetes.NewForConfig(m.kubernetes.config)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      m.Conf.Lease.Name,
+				Namespace: *m.Conf.Instance.Namespace,
+			},
+			Client: clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		},
+		Name:            m.Conf.Lease.Name,
+		ReleaseOnCancel: true,
+		LeaseDuration:   m.Conf.Lease.LeaseDuration,
+		RenewDeadline:   m.Conf.Lease.RenewDeadline,
+		RetryPeriod:     m.Conf.Lease.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				m.startLeading(ctx, syncInterval)
+			},
+			OnStoppedLeading: func() {
+				m.stopLeading()
+			},
+			OnNewLeader: func(leaderIdentity string) {
+				if leaderIdentity != identity {
+					m.Logger.Infof("current leader is %v", leaderIdentity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+
+	// retry leader election after losing leadership until shutdown
+	for m.leaderElection.ctx.Err() == nil && m.stopCtx.Err() == nil {
+		m.Logger.Infof("trying to become leader (identity %v)", identity)
+		elector.Run(m.leaderElection.ctx)
+	}
+}
+
+// startLeading starts the sync loops, they are stopped on shutdown or when leadership is lost
+// API calls of runs use the leader context (cancelled when leadership is lost or leader election is stopped after the shutdown timeout)
+func (m *MsiOperator) startLeading(leaderCtx context.Context, syncInterval time.Duration) {
+	ctx, cancel := context.WithCancel(context.WithValue(leaderCtx, leaderContextKey{}, leaderCtx))
+	go func() {
+		select {
+		case <-m.stopCtx.Done():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	m.Logger.Info("aquired leader lock, starting sync")
+	m.health.setLeader(true)
+	m.prometheus.leader.Set(1)
+
+	discoveryInterval := m.discoveryInterval(syncInterval)
+
+	// discovery reconciles changed Azure MSIs, reconcile schedule is the full resync of all namespaces
+	// (first discovery upserts all namespaces so first full resync is usually skipped because of lock time)
+	m.startScheduledSync(ctx, m.newSyncScheduler(SyncScheduleDiscovery, discoveryInterval), func(ctx context.Context, force bool) error {
+		return m.discoverAndReconcileChanges(ctx)
+	})
+	m.startScheduledSync(ctx, m.newSyncScheduler(SyncScheduleReconcile, syncInterval), func(ctx context.Context, force bool) error {
+		return m.reconcile(ctx, force)
+	})
+	m.startSyncTriggerWorker(ctx)
+
+	if m.Conf.EventGrid.Enabled {
+		m.startEventGridWorker(ctx)
+	}
+
+	if m.Conf.Sync.Watch {
+		m.startWatchSync(ctx)
+	}
+}
+
+// discoveryInterval returns the interval of the Azure MSI discovery (defaults to sync interval)
+func (m *MsiOperator) discoveryInterval(syncInterval time.Duration) time.Duration {
+	if m.Conf.Sync.DiscoveryInterval > 0 {
+		return m.Conf.Sync.DiscoveryInterval
+	}
+	return syncInterval
+}
+
+// leaderContext returns the leader context of the sync loop context (operator context if not started by startLeading)
+func (m *MsiOperator) leaderContext(ctx context.Context) context.Context {
+	if leaderCtx, ok := ctx.Value(leaderContextKey{}).(context.Context); ok {
+		return leaderCtx
+	}
+	return m.ctx
+}
+
+func (m *MsiOperator) stopLeading() {
+	// also called if leadership was never aquired or already released by stopLeaderElection
+	if !m.health.isLeader() {
+		return
+	}
+
+	m.Logger.Info("lost leader lock, stopping sync")
+	m.health.setLeader(false)
+	m.prometheus.leader.Set(0)
+}
+
+// stopLeaderElection stops the leader election and releases the lease
+func (m *MsiOperator) stopLeaderElection() {
+	m.health.setLeader(false)
+	m.prometheus.leader.Set(0)
+
+	if m.leaderElection.cancel == nil {
+		return
+	}
+
+	m.leaderElection.cancel()
+	select {
+	case <-m.leaderElection.done:
+		m.Logger.Info("released leader lock")
+	case <-time.After(leaderElectionReleaseTimeout):
+		m.Logger.Warn("timeout while releasing leader lock")
+	}
+}
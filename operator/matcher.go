@@ -0,0 +1,101 @@
+package operator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type (
+	// NamespaceMatcher decides if a namespace is maintained by the operator
+	//
+	// patterns are either globs (eg. "kube-*", "*-system") or regular expressions
+	// enclosed in slashes (eg. "/^team-[a-z]+$/")
+	NamespaceMatcher struct {
+		allow  []namespacePattern
+		ignore []namespacePattern
+	}
+
+	namespacePattern struct {
+		raw    string
+		regexp *regexp.Regexp
+	}
+)
+
+// NewNamespaceMatcher creates a matcher using allow and ignore pattern lists
+// an empty allow list allows all namespaces which are not ignored
+func NewNamespaceMatcher(allow, ignore []string) (*NamespaceMatcher, error) {
+	var err error
+	matcher := &NamespaceMatcher{}
+
+	if matcher.allow, err = parseNamespacePatterns(allow); err != nil {
+		return nil, fmt.Errorf("invalid namespace allow pattern: %w", err)
+	}
+
+	if matcher.ignore, err = parseNamespacePatterns(ignore); err != nil {
+		return nil, fmt.Errorf("invalid namespace ignore pattern: %w", err)
+	}
+
+	return matcher, nil
+}
+
+// IsAllowed returns true if namespace is not ignored and matches the allow list (if set)
+func (m *NamespaceMatcher) IsAllowed(namespace string) bool {
+	namespace = strings.ToLower(namespace)
+
+	for _, pattern := range m.ignore {
+		if pattern.match(namespace) {
+			return false
+		}
+	}
+
+	if len(m.allow) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.allow {
+		if pattern.match(namespace) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseNamespacePatterns(list []string) (ret []namespacePattern, err error) {
+	for _, val := range list {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+
+		pattern := namespacePattern{raw: val}
+		if len(val) >= 2 && strings.HasPrefix(val, "/") && strings.HasSuffix(val, "/") {
+			// regexp
+			pattern.regexp, err = regexp.Compile(val[1 : len(val)-1])
+			if err != nil {
+				return nil, fmt.Errorf("\"%s\": %w", val, err)
+			}
+		} else {
+			// glob, validate syntax
+			pattern.raw = strings.ToLower(val)
+			if _, err = path.Match(pattern.raw, ""); err != nil {
+				return nil, fmt.Errorf("\"%s\": %w", val, err)
+			}
+		}
+
+		ret = append(ret, pattern)
+	}
+
+	return
+}
+
+func (p namespacePattern) match(namespace string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(namespace)
+	}
+
+	matched, _ := path.Match(p.raw, namespace)
+	return matched
+}
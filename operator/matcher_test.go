@@ -0,0 +1,68 @@
+package operator
+
+import (
+	"testing"
+)
+
+func TestNamespaceMatcher(t *testing.T) {
+	matcher, err := NewNamespaceMatcher(
+		nil,
+		[]string{"default", "kube-*", "*-system", "/^tmp-[0-9]+$/"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"default":       false,
+		"kube-system":   false,
+		"kube-public":   false,
+		"istio-system":  false,
+		"tmp-123":       false,
+		"tmp-abc":       true,
+		"team-a":        true,
+		"defaultfoobar": true,
+		"Kube-Foo":      false,
+	}
+
+	for namespace, expected := range tests {
+		if result := matcher.IsAllowed(namespace); result != expected {
+			t.Errorf("namespace \"%s\": expected %v, got %v", namespace, expected, result)
+		}
+	}
+}
+
+func TestNamespaceMatcherAllow(t *testing.T) {
+	matcher, err := NewNamespaceMatcher(
+		[]string{"team-*", "/^app-(foo|bar)$/"},
+		[]string{"team-ignored"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"team-a":       true,
+		"team-ignored": false,
+		"app-foo":      true,
+		"app-bar":      true,
+		"app-foobar":   false,
+		"other":        false,
+	}
+
+	for namespace, expected := range tests {
+		if result := matcher.IsAllowed(namespace); result != expected {
+			t.Errorf("namespace \"%s\": expected %v, got %v", namespace, expected, result)
+		}
+	}
+}
+
+func TestNamespaceMatcherInvalid(t *testing.T) {
+	if _, err := NewNamespaceMatcher([]string{"team-["}, nil); err == nil {
+		t.Error("expected error for invalid glob pattern")
+	}
+
+	if _, err := NewNamespaceMatcher(nil, []string{"/team-(/"}); err == nil {
+		t.Error("expected error for invalid regexp pattern")
+	}
+}
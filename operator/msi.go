@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -15,15 +18,18 @@ import (
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/Azure/go-autorest/autorest/to"
-	"github.com/operator-framework/operator-lib/leader"
 	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"golang.org/x/sync/semaphore"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/webdevops/azure-msi-operator/config"
@@ -57,49 +63,148 @@ type (
 		UserAgent string
 		Logger    *zap.SugaredLogger
 
-		ctx        context.Context
-		runLock    *semaphore.Weighted
-		upsertLock *semaphore.Weighted
+		// ctx is used for API calls and cancelled after shutdown timeout
+		// stopCtx is cancelled on shutdown signal and stops all loops
+		ctx             context.Context
+		cancel          context.CancelFunc
+		stopCtx         context.Context
+		runLock         *semaphore.Weighted
+		upsertLock      *semaphore.Weighted
+		upsertLockUntil atomic.Int64
+		health          *healthStatus
+		syncTrigger     *syncTrigger
+		eventGrid       *eventGridQueue
+		history         *syncRunHistory
+		audit           *auditLog
+		notification    *notificationDispatcher
+		policy          *MsiPolicy
+
+		leaderElection struct {
+			ctx    context.Context
+			cancel context.CancelFunc
+			done   chan struct{}
+		}
 
 		kubernetes struct {
-			client dynamic.Interface
+			config           *rest.Config
+			client           dynamic.Interface
+			namespaceMatcher *NamespaceMatcher
+
+			// cluster the operator is running in and additional clusters (multi-cluster, by secret name)
+			cluster        *kubernetesCluster
+			remoteClusters map[string]*kubernetesCluster
 		}
 
 		azure struct {
 			environment      azure.Environment
 			authorizer       autorest.Authorizer
 			subscriptionList []subscriptions.Subscription
+
+			// system-assigned identities
+			graphClient               autorest.Client
+			servicePrincipalClientIds map[string]string
+
+			// role assignments by principal id
+			roleAssignments map[string][]MsiRoleAssignment
 		}
 
 		serviceDiscovery struct {
 			msi *MsiResourceList
 		}
 
+		webhook struct {
+			exemptNamespaces []namespacePattern
+		}
+
+		tracing struct {
+			provider *sdktrace.TracerProvider
+			tracer   trace.Tracer
+		}
+
 		prometheus struct {
-			msiResource        *prometheus.GaugeVec
+			leader             prometheus.Gauge
+			msiResource        *constGaugeVec
 			msiResourceSuccess *prometheus.CounterVec
 			msiResourceErrors  *prometheus.CounterVec
 			lastSync           *prometheus.GaugeVec
 			duration           *prometheus.GaugeVec
+			nextRun            *prometheus.GaugeVec
+			discoveryVersion   prometheus.Gauge
+			discoveryChanges   *prometheus.CounterVec
+			notifications      *prometheus.CounterVec
+
+			// multi-cluster
+			clusterUp       *prometheus.GaugeVec
+			clusterLastSync *prometheus.GaugeVec
+			clusterDuration *prometheus.GaugeVec
+			clusterErrors   *prometheus.CounterVec
+			clusterExcluded *prometheus.GaugeVec
+
+			// role assignments
+			highPrivilege *constGaugeVec
+
+			// policy
+			policyDenials *prometheus.CounterVec
+
+			// inventory
+			subscriptionResources  *constGaugeVec
+			resourcesSkipped       *constGaugeVec
+			azureIdentities        *constGaugeVec
+			azureIdentitiesOrphans *constGaugeVec
+		}
+
+		inventory struct {
+			// last list of AzureIdentities (throttled)
+			azureIdentitiesListed time.Time
 		}
 
 		msi struct {
-			resourceNameTemplate *template.Template
-			namespaceTemplate    *template.Template
+			resourceNameTemplate    *template.Template
+			namespaceTemplate       *template.Template
+			bindingSelectorTemplate *template.Template
+			clusterTemplate         *template.Template
 		}
 	}
+
+	// msiTemplateData is the data of an Azure MSI for the Golang templates
+	msiTemplateData struct {
+		Id             string
+		Name           string
+		Location       string
+		ResourceGroup  string
+		SubscriptionId string
+		ClientId       string
+		TenantId       string
+		PrincipalID    string
+		Tags           map[string]string
+		Type           string
+		Cluster        string
+
+		resourceName string
+	}
 )
 
-func (m *MsiOperator) Init() {
-	m.ctx = context.Background()
+func (m *MsiOperator) Init(ctx context.Context) {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.stopCtx = ctx
 	m.runLock = semaphore.NewWeighted(1)
 	m.upsertLock = semaphore.NewWeighted(1)
+	m.health = newHealthStatus()
+	m.syncTrigger = newSyncTrigger()
+	m.history = newSyncRunHistory(m.Conf.History.Size)
+	m.initAudit()
 
 	m.serviceDiscovery.msi = NewMsiResourceList()
 
 	m.initPrometheus()
+	m.initNotification()
+	m.initTracing()
 	m.initAzure()
+	m.initAzureSystemAssigned()
 	m.initKubernetes()
+	m.initPolicy()
+	m.initWebhook()
+	m.initEventGrid()
 
 	if t, err := template.New("msiResourceName").Parse(m.Conf.AzureIdentity.TemplateResourceName); err == nil {
 		m.msi.resourceNameTemplate = t
@@ -112,6 +217,18 @@ func (m *MsiOperator) Init() {
 	} else {
 		m.Logger.Panic(err)
 	}
+
+	if t, err := template.New("msiBindingSelector").Parse(m.Conf.AzureIdentity.Binding.TemplateSelector); err == nil {
+		m.msi.bindingSelectorTemplate = t
+	} else {
+		m.Logger.Panic(err)
+	}
+
+	if t, err := template.New("msiCluster").Parse(m.Conf.AzureIdentity.TemplateCluster); err == nil {
+		m.msi.clusterTemplate = t
+	} else {
+		m.Logger.Panic(err)
+	}
 }
 
 func (m *MsiOperator) initAzure() {
@@ -163,16 +280,93 @@ func (m *MsiOperator) initKubernetes() {
 		m.Logger.Panic(err)
 	}
 
+	// client side rate limiting
+	kubeconf.QPS = m.Conf.Kubernetes.QPS
+	kubeconf.Burst = m.Conf.Kubernetes.Burst
+
+	// trace Kubernetes API calls
+	kubeconf.Wrap(m.wrapKubernetesTransport)
+
 	// create kubernetes client
 	client, err := dynamic.NewForConfig(kubeconf)
 	if err != nil {
 		m.Logger.Panic(err)
 	}
 
+	m.kubernetes.config = kubeconf
 	m.kubernetes.client = client
+
+	// target clusters
+	m.kubernetes.cluster = &kubernetesCluster{
+		name:   strings.ToLower(m.Conf.Kubernetes.ClusterName),
+		local:  true,
+		client: client,
+	}
+	m.kubernetes.remoteClusters = map[string]*kubernetesCluster{}
+	if m.Conf.MultiCluster.Enabled && (m.Conf.Instance.Namespace == nil || *m.Conf.Instance.Namespace == "") {
+		m.Logger.Panic("multi-cluster mode requires instance namespace (--instance.namespace)")
+	}
+
+	// namespace filter
+	m.kubernetes.namespaceMatcher, err = NewNamespaceMatcher(m.Conf.Kubernetes.NamespaceAllow, m.Conf.Kubernetes.NamespaceIgnore)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+}
+
+func (m *MsiOperator) initWebhook() {
+	var err error
+	m.webhook.exemptNamespaces, err = parseNamespacePatterns(m.Conf.Webhook.ExemptNamespaces)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
 }
 
 func (m *MsiOperator) initPrometheus() {
+	m.prometheus.msiResource = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_resource_info",
+			Help: "Azure MSI operator discovered Azure MSI with sync status per namespace",
+		},
+		[]string{"subscription", "resourcegroup", "name", "clientid", "namespace", "status"},
+	)
+	prometheus.MustRegister(m.prometheus.msiResource)
+
+	m.prometheus.subscriptionResources = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_subscription_resources",
+			Help: "Azure MSI operator number of discovered Azure MSIs per subscription",
+		},
+		[]string{"subscription"},
+	)
+	prometheus.MustRegister(m.prometheus.subscriptionResources)
+
+	m.prometheus.resourcesSkipped = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_resources_skipped",
+			Help: "Azure MSI operator number of skipped Azure MSIs by reason",
+		},
+		[]string{"reason"},
+	)
+	prometheus.MustRegister(m.prometheus.resourcesSkipped)
+
+	m.prometheus.azureIdentities = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_kubernetes_azureidentities",
+			Help: "Azure MSI operator number of managed AzureIdentity resources per namespace",
+		},
+		[]string{"namespace"},
+	)
+	prometheus.MustRegister(m.prometheus.azureIdentities)
+
+	m.prometheus.azureIdentitiesOrphans = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_kubernetes_azureidentities_orphaned",
+			Help: "Azure MSI operator number of managed AzureIdentity resources without Azure MSI per namespace",
+		},
+		[]string{"namespace"},
+	)
+	prometheus.MustRegister(m.prometheus.azureIdentitiesOrphans)
 
 	m.prometheus.msiResourceSuccess = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -209,67 +403,150 @@ func (m *MsiOperator) initPrometheus() {
 		[]string{"subscription"},
 	)
 	prometheus.MustRegister(m.prometheus.lastSync)
-}
 
-func (m *MsiOperator) Start(syncInterval time.Duration) {
-	go func() {
-		m.leaderElect()
+	m.prometheus.leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_leader",
+			Help: "Azure MSI operator leader state (1 if leader)",
+		},
+	)
+	prometheus.MustRegister(m.prometheus.leader)
 
-		m.run()
-		m.startIntervalSync(syncInterval)
+	m.prometheus.nextRun = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_sync_next_run",
+			Help: "Azure MSI operator next scheduled run time",
+		},
+		[]string{"schedule"},
+	)
+	prometheus.MustRegister(m.prometheus.nextRun)
 
-		if m.Conf.Sync.Watch {
-			m.startWatchSync()
-		}
-	}()
-}
+	m.prometheus.discoveryVersion = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_discovery_version",
+			Help: "Azure MSI operator version of current discovery snapshot",
+		},
+	)
+	prometheus.MustRegister(m.prometheus.discoveryVersion)
 
-func (m *MsiOperator) leaderElect() {
-	if m.Conf.Lease.Enabled {
-		m.Logger.Info("trying to become leader")
-		if m.Conf.Instance.Pod != nil && os.Getenv("POD_NAME") == "" {
-			err := os.Setenv("POD_NAME", *m.Conf.Instance.Pod)
-			if err != nil {
-				m.Logger.Panic(err)
-			}
-		}
+	m.prometheus.discoveryChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azuremsi_discovery_changes",
+			Help: "Azure MSI operator changed resources between discovery snapshots",
+		},
+		[]string{"change"},
+	)
+	prometheus.MustRegister(m.prometheus.discoveryChanges)
 
-		time.Sleep(15 * time.Second)
-		err := leader.Become(m.ctx, m.Conf.Lease.Name)
-		if err != nil {
-			m.Logger.Error(err, "Failed to retry for leader lock")
-			os.Exit(1)
-		}
-		m.Logger.Info("aquired leader lock, continue")
-	}
+	m.prometheus.notifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azuremsi_notifications_total",
+			Help: "Azure MSI operator number of notifications by notifier and status",
+		},
+		[]string{"notifier", "status"},
+	)
+	prometheus.MustRegister(m.prometheus.notifications)
+
+	m.prometheus.clusterUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_cluster_up",
+			Help: "Azure MSI operator status of last upsert per cluster (1 = successful)",
+		},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(m.prometheus.clusterUp)
+
+	m.prometheus.clusterLastSync = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_cluster_sync_time",
+			Help: "Azure MSI operator last successful upsert time per cluster",
+		},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(m.prometheus.clusterLastSync)
+
+	m.prometheus.clusterDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_cluster_sync_duration",
+			Help: "Azure MSI operator upsert duration per cluster",
+		},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(m.prometheus.clusterDuration)
+
+	m.prometheus.clusterErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azuremsi_cluster_sync_errors",
+			Help: "Azure MSI operator failed Kubernetes resource syncs per cluster",
+		},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(m.prometheus.clusterErrors)
+
+	m.prometheus.clusterExcluded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_cluster_resources_excluded",
+			Help: "Azure MSI operator number of Azure MSIs excluded from the cluster by cluster filter",
+		},
+		[]string{"cluster"},
+	)
+	prometheus.MustRegister(m.prometheus.clusterExcluded)
+
+	m.prometheus.highPrivilege = newConstGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "azuremsi_resource_high_privilege",
+			Help: "Azure MSI operator discovered Azure MSI with high privilege role assignment at subscription (or higher) scope",
+		},
+		[]string{"subscription", "resourcegroup", "name", "clientid", "role", "scope"},
+	)
+	prometheus.MustRegister(m.prometheus.highPrivilege)
+
+	m.prometheus.policyDenials = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "azuremsi_policy_denials",
+			Help: "Azure MSI operator number of Azure MSI namespace syncs denied by policy",
+		},
+		[]string{"cluster", "subscription", "namespace", "rule"},
+	)
+	prometheus.MustRegister(m.prometheus.policyDenials)
 }
 
-func (m *MsiOperator) startIntervalSync(syncInterval time.Duration) {
-	go func() {
-		for {
-			time.Sleep(syncInterval)
-			m.run()
-		}
-	}()
+// watchUpsert upserts the namespace of a watch event as sync run, skipped if an upsert is already running or within the sync lock time
+func (m *MsiOperator) watchUpsert(ctx context.Context, trigger, namespace string, syncAzureIdentity, syncAzureIdentityBinding bool) {
+	if m.isUpsertLocked() {
+		// no sync run (history) for events caused by the last upsert
+		return
+	}
+
+	err := m.run(ctx, trigger, func(ctx context.Context) error {
+		return m.upsert(ctx, []string{namespace}, syncAzureIdentity, syncAzureIdentityBinding)
+	})
+	if err != nil {
+		m.Logger.Warnf("%s triggered sync of namespace %v failed: %v", trigger, namespace, err)
+	}
 }
 
-func (m *MsiOperator) startWatchSync() {
+func (m *MsiOperator) startWatchSync(ctx context.Context) {
 	// Namespace (create only) watch
 	go func() {
+		watchName := "Namespace"
 		for {
 			gvr := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
-			watch, err := m.kubernetes.client.Resource(gvr).Watch(m.ctx, metav1.ListOptions{Watch: true})
-			if err != nil {
+			watch, err := m.kubernetes.client.Resource(gvr).Watch(ctx, metav1.ListOptions{Watch: true})
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
 				m.Logger.Panic(err)
 			}
+			m.health.setWatchStatus(watchName, true)
 
 		watchLoop:
 			for event := range watch.ResultChan() {
 				switch strings.ToLower(string(event.Type)) {
 				case "added":
 					if obj, ok := event.Object.(*unstructured.Unstructured); ok {
-						if namespace := obj.GetName(); namespace != "" {
-							m.upsert(namespace, true, false)
+						if namespace := obj.GetName(); namespace != "" && m.kubernetes.namespaceMatcher.IsAllowed(namespace) {
+							m.watchUpsert(ctx, SyncRunTriggerWatchNamespace, namespace, true, false)
 						}
 					}
 				case "error":
@@ -277,26 +554,35 @@ func (m *MsiOperator) startWatchSync() {
 				}
 			}
 
+			if ctx.Err() != nil {
+				return
+			}
+
+			m.health.setWatchStatus(watchName, false)
 			m.Logger.Info("restarting Namespace watch")
 		}
 	}()
 
 	// AzureIdentityBinding watch
 	go func() {
+		watchName := K8sSchemeAzureIdentityBindingResourceSingular
 		for {
 			gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
-			watch, err := m.kubernetes.client.Resource(gvr).Watch(m.ctx, metav1.ListOptions{Watch: true})
-			if err != nil {
+			watch, err := m.kubernetes.client.Resource(gvr).Watch(ctx, metav1.ListOptions{Watch: true})
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
 				m.Logger.Panic(err)
 			}
+			m.health.setWatchStatus(watchName, true)
 
 		watchLoop:
 			for event := range watch.ResultChan() {
 				switch strings.ToLower(string(event.Type)) {
 				case "added", "modified":
 					if obj, ok := event.Object.(*unstructured.Unstructured); ok {
-						if namespace := obj.GetNamespace(); namespace != "" {
-							m.upsert(namespace, false, true)
+						if namespace := obj.GetNamespace(); namespace != "" && m.kubernetes.namespaceMatcher.IsAllowed(namespace) {
+							m.watchUpsert(ctx, SyncRunTriggerWatchBinding, namespace, false, true)
 						}
 					}
 				case "error":
@@ -304,34 +590,123 @@ func (m *MsiOperator) startWatchSync() {
 				}
 			}
 
+			if ctx.Err() != nil {
+				return
+			}
+
+			m.health.setWatchStatus(watchName, false)
 			m.Logger.Info("restarting AzureIdentityBinding watch")
 		}
 	}()
 }
 
-func (m *MsiOperator) run() {
-	if !m.runLock.TryAcquire(1) {
-		// already running
-		return
+// Shutdown waits for running syncs (until timeout), releases the leader lease and cancels all API calls afterwards
+func (m *MsiOperator) Shutdown(timeout time.Duration) {
+	m.Logger.Info("shutting down operator")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// block running and future syncs
+	if err := m.runLock.Acquire(ctx, 1); err != nil {
+		m.Logger.Warnf("sync still running after %v, cancelling", timeout.String())
+	} else if err := m.upsertLock.Acquire(ctx, 1); err != nil {
+		m.Logger.Warnf("upsert still running after %v, cancelling", timeout.String())
+	}
+
+	m.stopLeaderElection()
+	m.shutdownAudit(ctx)
+	m.shutdownNotification(ctx)
+	m.shutdownTracing(ctx)
+	m.cancel()
+	m.Logger.Info("operator stopped")
+}
+
+// sync runs the Azure MSI servicediscovery and upserts the Kubernetes resources of the namespaces (all if empty)
+// namespaces affected by discovered changes are also upserted
+// forced syncs wait for running upserts and ignore the sync lock time
+func (m *MsiOperator) sync(ctx context.Context, force bool, namespaces ...string) error {
+	diff, err := m.discover(ctx)
+	if err != nil {
+		return err
 	}
-	defer m.runLock.Release(1)
 
+	if len(namespaces) > 0 {
+		for _, namespace := range diff.Namespaces() {
+			if !contains(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+	}
+
+	return m.reconcile(ctx, force, namespaces...)
+}
+
+// discover runs the Azure MSI servicediscovery and commits a new snapshot
+func (m *MsiOperator) discover(ctx context.Context) (*MsiResourceListDiff, error) {
 	m.Logger.Info("starting ServiceDiscovery")
-	overallStartTime := time.Now()
 
-	if err := m.updateAzureMsiList(); err != nil {
-		m.Logger.Errorf("failed to update Azure MSI list: %v", err)
-		return
+	ctx, span := m.startSpan(ctx, "discover")
+	diff, err := m.updateAzureMsiList(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to update Azure MSI list: %w", err)
+		m.Logger.Error(err)
+	} else {
+		span.SetAttributes(TraceAttrSnapshotVersion.Int64(int64(diff.Version)))
 	}
+	endSpan(span, err)
+	return diff, err
+}
 
-	m.upsert("", true, true)
+// discoverAndReconcileChanges runs the Azure MSI servicediscovery and upserts only the namespaces affected by changes
+func (m *MsiOperator) discoverAndReconcileChanges(ctx context.Context) error {
+	diff, err := m.discover(ctx)
+	if err != nil {
+		return err
+	}
 
-	overallDuration := time.Since(overallStartTime)
-	m.Logger.Infof("finished after %s", overallDuration.String())
+	return m.reconcileChanges(ctx, diff)
 }
 
-func (m *MsiOperator) updateAzureMsiList() error {
+// reconcileChanges upserts the namespaces affected by the changes of a snapshot
+func (m *MsiOperator) reconcileChanges(ctx context.Context, diff *MsiResourceListDiff) error {
+	if !diff.HasChanges() {
+		m.Logger.Infof("no changes in Azure MSI snapshot v%d", diff.Version)
+		return nil
+	}
+
+	namespaces := diff.Namespaces()
+	m.Logger.Infof(
+		"changes in Azure MSI snapshot v%d (added: %d, changed: %d, removed: %d), affected namespaces: %v",
+		diff.Version,
+		len(diff.Added),
+		len(diff.Changed),
+		len(diff.Removed),
+		strings.Join(namespaces, ", "),
+	)
+
+	if len(namespaces) == 0 {
+		// only removed resources or resources without namespace
+		return nil
+	}
+
+	// changes are reconciled immediately (ignoring the sync lock time)
+	return m.reconcile(ctx, true, namespaces...)
+}
+
+// reconcile upserts the Kubernetes resources of the namespaces (all if empty) using the last discovered Azure MSIs
+// all namespaces are upserted in one pass over the Azure MSIs
+func (m *MsiOperator) reconcile(ctx context.Context, force bool, namespaces ...string) error {
+	if force {
+		return m.forceUpsert(ctx, namespaces, true, true)
+	}
+	return m.upsert(ctx, namespaces, true, true)
+}
+
+func (m *MsiOperator) updateAzureMsiList(ctx context.Context) (*MsiResourceListDiff, error) {
 	m.serviceDiscovery.msi.Clean()
+	m.updateAzureRoleAssignments(ctx)
+
 	for _, v := range m.azure.subscriptionList {
 		subscription := v
 
@@ -339,11 +714,25 @@ func (m *MsiOperator) updateAzureMsiList() error {
 
 		contextLogger := m.Logger.With(zap.String("subscription", *subscription.DisplayName))
 
+		subscriptionCtx, span := m.startSpan(ctx, "discover subscription", TraceAttrAzureSubscriptionId.String(to.String(subscription.SubscriptionID)))
+
 		contextLogger.Infof("running MSI servicediscovery in Azure Subscription \"%s\" (%s)", to.String(subscription.DisplayName), to.String(subscription.SubscriptionID))
-		resourceList, err := m.fetchAzureMsiList(&subscription)
+		resourceList, err := m.fetchAzureMsiList(subscriptionCtx, &subscription)
 		if err != nil {
-			return err
+			endSpan(span, err)
+			return nil, err
+		}
+
+		if len(m.Conf.Azure.SystemAssigned) > 0 {
+			systemAssignedList, err := m.fetchAzureSystemAssignedIdentityList(subscriptionCtx, &subscription)
+			if err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+			resourceList = append(resourceList, systemAssignedList...)
 		}
+		m.history.setSubscription(to.String(subscription.SubscriptionID), len(resourceList))
+		span.SetAttributes(TraceAttrAzureMsiCount.Int(len(resourceList)))
 
 		for _, msiResource := range resourceList {
 			msiInfo, err := m.generateMsiKubernetesResourceInfo(msiResource)
@@ -358,37 +747,139 @@ func (m *MsiOperator) updateAzureMsiList() error {
 		subscriptionSyncDuration := time.Since(subscriptionStartTime)
 		m.prometheus.duration.WithLabelValues(*subscription.SubscriptionID).Set(subscriptionSyncDuration.Seconds())
 		m.prometheus.lastSync.WithLabelValues(*subscription.SubscriptionID).SetToCurrentTime()
+		span.End()
 	}
 
-	m.serviceDiscovery.msi.Commit()
+	diff := m.serviceDiscovery.msi.Commit()
+	m.observeSnapshot(diff)
 
-	return nil
+	return diff, nil
+}
+
+func (m *MsiOperator) observeSnapshot(diff *MsiResourceListDiff) {
+	m.prometheus.discoveryVersion.Set(float64(diff.Version))
+	m.prometheus.discoveryChanges.WithLabelValues("added").Add(float64(len(diff.Added)))
+	m.prometheus.discoveryChanges.WithLabelValues("changed").Add(float64(len(diff.Changed)))
+	m.prometheus.discoveryChanges.WithLabelValues("removed").Add(float64(len(diff.Removed)))
 }
 
-func (m *MsiOperator) upsert(namespaceFilter string, syncAzureIdentity, syncAzureIdentityBinding bool) bool {
+// upsert upserts the Kubernetes resources, skipped if an upsert is already running or within the sync lock time
+func (m *MsiOperator) upsert(ctx context.Context, namespaces []string, syncAzureIdentity, syncAzureIdentityBinding bool) error {
 	if !m.upsertLock.TryAcquire(1) {
 		// already running
-		return false
+		return nil
+	}
+	defer m.upsertLock.Release(1)
+
+	if m.isUpsertLocked() {
+		// next sync is locked
+		return nil
 	}
+
+	return m.upsertResources(ctx, namespaces, syncAzureIdentity, syncAzureIdentityBinding)
+}
+
+// isUpsertLocked returns true if upserts are locked by the sync lock time (forced upserts ignore the lock)
+func (m *MsiOperator) isUpsertLocked() bool {
+	return time.Now().UnixNano() < m.upsertLockUntil.Load()
+}
+
+// forceUpsert waits for running upserts and ignores the sync lock time
+func (m *MsiOperator) forceUpsert(ctx context.Context, namespaces []string, syncAzureIdentity, syncAzureIdentityBinding bool) error {
+	if err := m.upsertLock.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer m.upsertLock.Release(1)
+
+	return m.upsertResources(ctx, namespaces, syncAzureIdentity, syncAzureIdentityBinding)
+}
+
+// upsertResources upserts the Kubernetes resources of the namespaces (all if empty) in all target clusters, returns an error if resources failed to sync
+// clusters are upserted concurrently, failures of a cluster don't affect the other clusters
+func (m *MsiOperator) upsertResources(ctx context.Context, namespaces []string, syncAzureIdentity, syncAzureIdentityBinding bool) (err error) {
+	snapshotVersion := m.serviceDiscovery.msi.Version()
+	ctx, span := m.startSpan(
+		ctx, "upsert",
+		TraceAttrK8sNamespace.StringSlice(namespaces),
+		TraceAttrSnapshotVersion.Int64(int64(snapshotVersion)),
+	)
+	defer func() {
+		endSpan(span, err)
+
+		// lock next sync
+		m.upsertLockUntil.Store(time.Now().Add(m.Conf.Sync.LockTime).UnixNano())
+	}()
+
+	filter := newNamespaceFilter(namespaces)
+	clusters := m.targetClusters(ctx)
+	if len(clusters) == 1 {
+		return m.upsertCluster(ctx, clusters[0], filter, snapshotVersion, syncAzureIdentity, syncAzureIdentityBinding)
+	}
+
+	var (
+		lock           sync.Mutex
+		failedClusters []string
+		wg             sync.WaitGroup
+	)
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster *kubernetesCluster) {
+			defer wg.Done()
+			if err := m.upsertCluster(ctx, cluster, filter, snapshotVersion, syncAzureIdentity, syncAzureIdentityBinding); err != nil {
+				lock.Lock()
+				failedClusters = append(failedClusters, cluster.name)
+				lock.Unlock()
+			}
+		}(cluster)
+	}
+	wg.Wait()
+
+	if len(failedClusters) > 0 {
+		sort.Strings(failedClusters)
+		return fmt.Errorf("failed to sync Kubernetes resources in clusters: %s", strings.Join(failedClusters, ", "))
+	}
+	return nil
+}
+
+// upsertCluster upserts the Kubernetes resources of the namespaces (all if filter is empty) of a cluster, returns an error if resources failed to sync
+func (m *MsiOperator) upsertCluster(ctx context.Context, cluster *kubernetesCluster, filter namespaceFilter, snapshotVersion uint64, syncAzureIdentity, syncAzureIdentityBinding bool) (err error) {
+	startTime := time.Now()
+
+	// Kubernetes writes are done concurrently (limited by --kubernetes.write.concurrency)
+	var (
+		failed int64
+		wg     sync.WaitGroup
+	)
+
+	ctx, span := m.startSpan(contextWithCluster(ctx, cluster), "upsert cluster", TraceAttrK8sCluster.String(cluster.name))
 	defer func() {
-		go func() {
-			// lock next sync (keep up semaphore lock)
-			time.Sleep(m.Conf.Sync.LockTime)
-			m.upsertLock.Release(1)
-		}()
+		endSpan(span, err)
+		m.observeCluster(cluster, time.Since(startTime).Seconds(), int(failed), err)
 	}()
 
-	if namespaceFilter == "" {
-		m.Logger.Info("starting upsert for cluster")
+	clusterLogger := m.Logger.With(zap.String("cluster", cluster.name))
+	if len(filter) == 0 {
+		clusterLogger.Infof("starting upsert for cluster \"%s\" (snapshot v%d)", cluster.name, snapshotVersion)
 	} else {
-		m.Logger.Infof("starting upsert for namespace %v", namespaceFilter)
+		clusterLogger.Infof("starting upsert for namespaces %v in cluster \"%s\" (snapshot v%d)", filter, cluster.name, snapshotVersion)
+	}
+
+	var azureIdentities *azureIdentityCache
+	if syncAzureIdentity {
+		// one list call instead of a get per AzureIdentity
+		azureIdentities, err = m.listAzureIdentityCache(ctx, cluster, filter)
+		if err != nil {
+			clusterLogger.Warnf("unable to cache AzureIdentities, fetching them one by one: %v", err)
+		}
 	}
+	cache := newUpsertCache(azureIdentities)
 
-	for _, msiResource := range m.serviceDiscovery.msi.GetList() {
+	writeLock := semaphore.NewWeighted(int64(m.kubernetesWriteConcurrency()))
+	for _, msiResource := range m.clusterMsiList(cluster) {
 		resourceId := to.String(msiResource.AzureResourceId)
 
 		// add resource to log
-		msiLogger := m.Logger.With(zap.String("resource", resourceId))
+		msiLogger := clusterLogger.With(zap.String("resource", resourceId))
 
 		// check if namespace/resource was found
 		if msiResource.KubernetesNamespace == nil {
@@ -401,63 +892,147 @@ func (m *MsiOperator) upsert(namespaceFilter string, syncAzureIdentity, syncAzur
 			continue
 		}
 
-		k8sResourceName := *msiResource.KubernetesResourceName
 		for _, k8sNamespace := range msiResource.KubernetesNamespace {
-			if namespaceFilter != "" && k8sNamespace != namespaceFilter {
+			if !filter.matches(k8sNamespace) {
 				continue
 			}
 
-			// add k8s info to log
-			msiLogger = msiLogger.With(
-				zap.String("k8sNamespace", k8sNamespace),
-				zap.String("k8sResource", *msiResource.KubernetesResourceName),
-			)
-
-			// sync AzureIdentity
-			if syncAzureIdentity {
-				msiLogger.Debugf("sync AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
-				if err := m.syncAzureIdentity(msiLogger, msiResource, k8sNamespace); err != nil {
-					msiLogger.Errorf("failed to sync AzureIdentity: %v", err)
-				}
+			if err := writeLock.Acquire(ctx, 1); err != nil {
+				// cancelled
+				wg.Wait()
+				return err
 			}
 
-			// sync AzureIdentityBinding
-			if syncAzureIdentityBinding && m.Conf.AzureIdentity.Binding.Sync {
-				msiLogger.Debugf("sync AzureIdentityBinding for AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
-				err := m.syncAzureIdentityToAzureIdentityBinding(msiLogger, msiResource, k8sNamespace)
-				if err != nil {
-					msiLogger.Error(err)
-				}
-			}
+			wg.Add(1)
+			go func(msiResource MsiResourceInfo, k8sNamespace string) {
+				defer wg.Done()
+				defer writeLock.Release(1)
+				atomic.AddInt64(&failed, int64(m.upsertMsiResource(ctx, cluster, msiLogger, msiResource, k8sNamespace, cache, syncAzureIdentity, syncAzureIdentityBinding)))
+			}(msiResource, k8sNamespace)
 		}
 	}
+	wg.Wait()
 
-	return true
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d Kubernetes resources", failed)
+	}
+	return nil
 }
 
-func (m *MsiOperator) syncAzureIdentity(contextLogger *zap.SugaredLogger, msiResource MsiResourceInfo, k8sNamespace string) error {
-	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
-	subscriptionId := to.String(msiResource.AzureSubscriptionId)
+// upsertMsiResource upserts the Kubernetes resources of an Azure MSI in a namespace, returns the number of failed resources
+func (m *MsiOperator) upsertMsiResource(ctx context.Context, cluster *kubernetesCluster, msiLogger *zap.SugaredLogger, msiResource MsiResourceInfo, k8sNamespace string, cache *upsertCache, syncAzureIdentity, syncAzureIdentityBinding bool) (failed int) {
+	resourceId := to.String(msiResource.AzureResourceId)
 	k8sResourceName := *msiResource.KubernetesResourceName
 
+	ctx, span := m.startSpan(
+		ctx, "upsert azure msi",
+		TraceAttrAzureSubscriptionId.String(to.String(msiResource.AzureSubscriptionId)),
+		TraceAttrAzureResourceId.String(resourceId),
+		TraceAttrK8sNamespace.String(k8sNamespace),
+		TraceAttrK8sResourceName.String(k8sResourceName),
+	)
+	defer func() {
+		var err error
+		if failed > 0 {
+			err = fmt.Errorf("failed to sync %d Kubernetes resources", failed)
+		}
+		endSpan(span, err)
+	}()
+
+	// add k8s info to log
+	msiLogger = msiLogger.With(
+		zap.String("k8sNamespace", k8sNamespace),
+		zap.String("k8sResource", k8sResourceName),
+	)
+
+	// check policy (before namespace creation)
+	allowed, err := m.checkMsiPolicy(ctx, cluster, msiLogger, msiResource, k8sNamespace, cache)
+	if err != nil {
+		msiLogger.Error(err)
+		m.history.addError("%s: %v", resourceId, err)
+		m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusFailed)
+		return 1
+	}
+
+	if !allowed {
+		return 0
+	}
+
+	// check namespace
+	namespaceCheck := cache.namespaceCheck(k8sNamespace)
+	namespaceCheck.once.Do(func() {
+		namespaceCheck.exists, namespaceCheck.err = m.checkKubernetesNamespace(ctx, cluster, msiLogger, msiResource, k8sNamespace)
+	})
+
+	if namespaceCheck.err != nil {
+		msiLogger.Error(namespaceCheck.err)
+		m.history.addError("%s: %v", resourceId, namespaceCheck.err)
+		m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusFailed)
+		return 1
+	}
+
+	if !namespaceCheck.exists {
+		m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusNamespaceMissing)
+		return 0
+	}
+
 	// sync AzureIdentity
-	azureIdentityObj, _ := m.kubernetes.client.Resource(gvr).Namespace(k8sNamespace).Get(m.ctx, k8sResourceName, metav1.GetOptions{})
-	if azureIdentityObj != nil {
-		// update
-		contextLogger.Infof("updating AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
+	azureIdentityFailed := false
+	if syncAzureIdentity {
+		msiLogger.Debugf("sync AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
+		if err := m.syncAzureIdentity(ctx, cluster, msiLogger, msiResource, k8sNamespace, cache.azureIdentities); err != nil {
+			msiLogger.Errorf("failed to sync AzureIdentity: %v", err)
+			m.history.addError("%s: failed to sync AzureIdentity \"%s/%s\": %v", resourceId, k8sNamespace, k8sResourceName, err)
+			m.notify(ctx, NotificationEventFailed, msiResource, K8sSchemeAzureIdentityResourceSingular, k8sNamespace, k8sResourceName, err)
+			m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusFailed)
+			azureIdentityFailed = true
+			failed++
+		} else {
+			m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusSynced)
+		}
+	}
 
-		if err := m.applyMsiToK8sObject(msiResource.Resource, azureIdentityObj); err != nil {
-			return err
+	// generate AzureIdentityBinding (not for failed AzureIdentity, binding would reference a missing AzureIdentity)
+	if syncAzureIdentity && !azureIdentityFailed && m.Conf.AzureIdentity.Binding.Generate {
+		msiLogger.Debugf("generate AzureIdentityBinding for AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
+		if err := m.generateAzureIdentityBinding(ctx, cluster, msiLogger, msiResource, k8sNamespace); err != nil {
+			msiLogger.Errorf("failed to generate AzureIdentityBinding: %v", err)
+			m.history.addError("%s: failed to generate AzureIdentityBinding \"%s/%s\": %v", resourceId, k8sNamespace, k8sResourceName, err)
+			m.notify(ctx, NotificationEventFailed, msiResource, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, k8sResourceName, err)
+			m.setMsiStatus(cluster, resourceId, k8sNamespace, MsiSyncStatusFailed)
+			failed++
 		}
+	}
 
-		_, err := m.kubernetes.client.Resource(gvr).Namespace(k8sNamespace).Update(m.ctx, azureIdentityObj, metav1.UpdateOptions{})
+	// sync AzureIdentityBinding
+	if syncAzureIdentityBinding && m.Conf.AzureIdentity.Binding.Sync {
+		msiLogger.Debugf("sync AzureIdentityBinding for AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
+		err := m.syncAzureIdentityToAzureIdentityBinding(ctx, cluster, msiLogger, msiResource, k8sNamespace)
 		if err != nil {
-			contextLogger.Error(err)
-			m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
-		} else {
-			m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+			msiLogger.Error(err)
+			m.history.addError("%s: failed to sync AzureIdentityBindings in namespace \"%s\": %v", resourceId, k8sNamespace, err)
+			m.notify(ctx, NotificationEventFailed, msiResource, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, "", err)
+			failed++
 		}
-	} else {
+	}
+
+	return failed
+}
+
+// syncAzureIdentity creates or updates the AzureIdentity of an Azure MSI in a namespace
+// cached AzureIdentities are used for comparison, without cache the AzureIdentity is fetched
+func (m *MsiOperator) syncAzureIdentity(ctx context.Context, cluster *kubernetesCluster, contextLogger *zap.SugaredLogger, msiResource MsiResourceInfo, k8sNamespace string, cache *azureIdentityCache) error {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
+	subscriptionId := to.String(msiResource.AzureSubscriptionId)
+	k8sResourceName := *msiResource.KubernetesResourceName
+
+	// sync AzureIdentity
+	azureIdentityObj := cache.get(k8sNamespace, k8sResourceName)
+	if azureIdentityObj == nil && cache == nil {
+		azureIdentityObj, _ = cluster.client.Resource(gvr).Namespace(k8sNamespace).Get(ctx, k8sResourceName, metav1.GetOptions{})
+	}
+
+	if azureIdentityObj == nil {
 		// create
 		contextLogger.Infof("creating AzureIdentity \"%s/%s\"", k8sNamespace, k8sResourceName)
 
@@ -473,75 +1048,95 @@ func (m *MsiOperator) syncAzureIdentity(contextLogger *zap.SugaredLogger, msiRes
 			},
 		}
 
-		if err := m.applyMsiToK8sObject(msiResource.Resource, azureIdentityObj); err != nil {
+		if err := m.applyMsiToK8sObject(msiResource, azureIdentityObj); err != nil {
 			return err
 		}
 
-		_, err := m.kubernetes.client.Resource(gvr).Namespace(k8sNamespace).Create(m.ctx, azureIdentityObj, metav1.CreateOptions{})
+		_, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Create(ctx, azureIdentityObj, metav1.CreateOptions{})
+		if err == nil || !errors.IsAlreadyExists(err) {
+			m.auditChange(ctx, AuditActionCreate, msiResource, K8sSchemeAzureIdentityResourceSingular, k8sNamespace, k8sResourceName, nil, azureIdentityObj, err)
+			if err != nil {
+				m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+				m.history.count(SyncResultError)
+				return err
+			}
+			m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+			m.history.count(SyncResultCreated)
+			m.notify(ctx, NotificationEventGranted, msiResource, K8sSchemeAzureIdentityResourceSingular, k8sNamespace, k8sResourceName, nil)
+			return nil
+		}
+
+		// existing AzureIdentity is not cached (no managed-by label), update it
+		azureIdentityObj, err = cluster.client.Resource(gvr).Namespace(k8sNamespace).Get(ctx, k8sResourceName, metav1.GetOptions{})
 		if err != nil {
-			contextLogger.Error(err)
 			m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
-		} else {
-			m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+			m.history.count(SyncResultError)
+			return fmt.Errorf("failed to fetch AzureIdentity \"%s/%s\": %w", k8sNamespace, k8sResourceName, err)
 		}
 	}
 
-	return nil
-}
-
-func (m *MsiOperator) syncAzureIdentityToAzureIdentityBinding(contextLogger *zap.SugaredLogger, msiInfo MsiResourceInfo, k8sNamespace string) error {
-	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
-
-	labelNameSubscription := m.labelName("subscription")
-	labelValueSubscription := to.String(msiInfo.AzureSubscriptionId)
-
-	labelNameResourceGroup := m.labelName("resourcegroup")
-	labelValueResourceGroup := to.String(msiInfo.AzureResourceGroup)
-
-	labelNameResourceName := m.labelName("name")
-	labelValueResourceName := to.String(msiInfo.AzureResourceName)
-
-	if validationErrors := validation.IsValidLabelValue(labelValueSubscription); len(validationErrors) != 0 {
-		return fmt.Errorf("invalid label value \"%s\" for subscription: %v", labelValueSubscription, validationErrors)
+	// update
+	original := azureIdentityObj.DeepCopy()
+	if err := m.applyMsiToK8sObject(msiResource, azureIdentityObj); err != nil {
+		return err
 	}
 
-	if validationErrors := validation.IsValidLabelValue(labelValueResourceGroup); len(validationErrors) != 0 {
-		return fmt.Errorf("invalid label value \"%s\" for resourcegroup: %v", labelValueResourceGroup, validationErrors)
+	if reflect.DeepEqual(original.Object, azureIdentityObj.Object) {
+		contextLogger.Debugf("AzureIdentity %v/%v is unchanged", k8sNamespace, k8sResourceName)
+		m.history.count(SyncResultUnchanged)
+		m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+		return nil
 	}
 
-	if validationErrors := validation.IsValidLabelValue(labelValueResourceName); len(validationErrors) != 0 {
-		return fmt.Errorf("invalid label value \"%s\" for resourcename: %v", labelValueResourceName, validationErrors)
+	contextLogger.Infof("updating AzureIdentity %v/%v", k8sNamespace, k8sResourceName)
+	_, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Update(ctx, azureIdentityObj, metav1.UpdateOptions{})
+	m.auditChange(ctx, AuditActionUpdate, msiResource, K8sSchemeAzureIdentityResourceSingular, k8sNamespace, k8sResourceName, original, azureIdentityObj, err)
+	if err != nil {
+		m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+		m.history.count(SyncResultError)
+		return err
 	}
+	m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, K8sSchemeAzureIdentityResourceSingular).Inc()
+	m.history.count(SyncResultUpdated)
 
-	listOpts := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf(
-			"%s=%s,%s=%s,%s=%s",
-			labelNameSubscription, labelValueSubscription,
-			labelNameResourceGroup, labelValueResourceGroup,
-			labelNameResourceName, labelValueResourceName,
-		),
-	}
+	return nil
+}
+
+func (m *MsiOperator) syncAzureIdentityToAzureIdentityBinding(ctx context.Context, cluster *kubernetesCluster, contextLogger *zap.SugaredLogger, msiInfo MsiResourceInfo, k8sNamespace string) error {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
 
-	list, err := m.kubernetes.client.Resource(gvr).Namespace(k8sNamespace).List(m.ctx, listOpts)
+	list, err := m.lookupAzureIdentityBindings(ctx, cluster, msiInfo, k8sNamespace)
 	if err != nil {
-		return fmt.Errorf("failed to fetch AzureIdentityBinding from namespace \"%s\": %w", k8sNamespace, err)
+		return err
 	}
 
 	if list != nil {
 		for _, item := range list.Items {
 			azureIdentityBinding := item
+			original := item.DeepCopy()
+			if currentAzureIdentity, _, _ := unstructured.NestedString(azureIdentityBinding.Object, "spec", "azureIdentity"); currentAzureIdentity == *msiInfo.KubernetesResourceName {
+				contextLogger.Debugf("AzureIdentityBinding \"%s/%s\" is unchanged", k8sNamespace, azureIdentityBinding.GetName())
+				m.history.count(SyncResultUnchanged)
+				continue
+			}
+
 			if err := unstructured.SetNestedField(azureIdentityBinding.Object, *msiInfo.KubernetesResourceName, "spec", "azureIdentity"); err != nil {
 				contextLogger.Warnf("failed to set object \"kind\" for AzureIdentityBinding \"%s/%s\": %v", k8sNamespace, azureIdentityBinding.GetName(), err)
 				continue
 			}
 
-			_, err := m.kubernetes.client.Resource(gvr).Namespace(k8sNamespace).Update(m.ctx, &azureIdentityBinding, metav1.UpdateOptions{})
+			_, err := cluster.client.Resource(gvr).Namespace(k8sNamespace).Update(ctx, &azureIdentityBinding, metav1.UpdateOptions{})
+			m.auditChange(ctx, AuditActionRewire, msiInfo, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, azureIdentityBinding.GetName(), original, &azureIdentityBinding, err)
 			if err != nil {
 				contextLogger.Warnf("unable to sync AzureIdentity \"%[1]s/%[3]s\" to AzureIdentityBinding \"%[1]s/%[2]s\" : %[4]v", k8sNamespace, azureIdentityBinding.GetName(), *msiInfo.KubernetesResourceName, err)
 				m.prometheus.msiResourceErrors.WithLabelValues(*msiInfo.AzureSubscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+				m.history.count(SyncResultError)
+				m.history.addError("%s: unable to sync AzureIdentityBinding \"%s/%s\": %v", to.String(msiInfo.AzureResourceId), k8sNamespace, azureIdentityBinding.GetName(), err)
+				m.notify(ctx, NotificationEventFailed, msiInfo, K8sSchemeAzureIdentityBindingResourceSingular, k8sNamespace, azureIdentityBinding.GetName(), err)
 			} else {
 				contextLogger.Infof("successfully synced AzureIdentity \"%[1]s/%[3]s\" to AzureIdentityBinding \"%[1]s/%[2]s\"", k8sNamespace, azureIdentityBinding.GetName(), *msiInfo.KubernetesResourceName)
 				m.prometheus.msiResourceSuccess.WithLabelValues(*msiInfo.AzureSubscriptionId, K8sSchemeAzureIdentityBindingResourceSingular).Inc()
+				m.history.count(SyncResultUpdated)
 			}
 		}
 	}
@@ -553,41 +1148,26 @@ func (m *MsiOperator) generateMsiKubernetesResourceInfo(msi *msi.Identity) (msiI
 	msiInfo = MsiResourceInfo{
 		AzureResourceId: to.StringPtr(strings.ToLower(to.String(msi.ID))),
 		Resource:        msi,
+		SystemAssigned:  msi.Type != nil && !strings.EqualFold(*msi.Type, AzureMsiResourceTypeUserAssigned),
 	}
+	msiInfo.SyncDisabled = msiInfo.SystemAssigned && !m.Conf.Azure.SystemAssignedSync
 
-	resourceInfo, parseErr := azure.ParseResourceID(*msi.ID)
-	if parseErr != nil {
-		err = parseErr
+	templateData, err := newMsiTemplateData(msi, m.kubernetes.cluster.name)
+	if err != nil {
 		return
 	}
 
-	templateData := struct {
-		Id             string
-		Name           string
-		Location       string
-		ResourceGroup  string
-		SubscriptionId string
-		ClientId       string
-		TenantId       string
-		PrincipalID    string
-		Tags           map[string]string
-		Type           string
-	}{
-		Id:             to.String(msi.ID),
-		Name:           to.String(msi.Name),
-		Location:       to.String(msi.Location),
-		ResourceGroup:  resourceInfo.ResourceGroup,
-		SubscriptionId: resourceInfo.SubscriptionID,
-		ClientId:       msi.ClientID.String(),
-		TenantId:       msi.TenantID.String(),
-		PrincipalID:    msi.PrincipalID.String(),
-		Tags:           to.StringMap(msi.Tags),
-		Type:           to.String(msi.Type),
-	}
+	msiInfo.AzureResourceName = to.StringPtr(strings.ToLower(templateData.resourceName))
+	msiInfo.AzureResourceGroup = to.StringPtr(strings.ToLower(templateData.ResourceGroup))
+	msiInfo.AzureSubscriptionId = to.StringPtr(strings.ToLower(templateData.SubscriptionId))
 
-	msiInfo.AzureResourceName = to.StringPtr(strings.ToLower(resourceInfo.ResourceName))
-	msiInfo.AzureResourceGroup = to.StringPtr(strings.ToLower(resourceInfo.ResourceGroup))
-	msiInfo.AzureSubscriptionId = to.StringPtr(strings.ToLower(resourceInfo.SubscriptionID))
+	// nil until role assignments have been fetched
+	if m.azure.roleAssignments != nil && msi.UserAssignedIdentityProperties != nil && msi.PrincipalID != nil {
+		msiInfo.RoleAssignments = m.azure.roleAssignments[strings.ToLower(msi.PrincipalID.String())]
+		if msiInfo.RoleAssignments == nil {
+			msiInfo.RoleAssignments = []MsiRoleAssignment{}
+		}
+	}
 
 	resNameBuf := &bytes.Buffer{}
 	if err := m.msi.resourceNameTemplate.Execute(resNameBuf, templateData); err != nil {
@@ -597,29 +1177,82 @@ func (m *MsiOperator) generateMsiKubernetesResourceInfo(msi *msi.Identity) (msiI
 		msiInfo.KubernetesResourceName = &val
 	}
 
-	namespaceBuf := &bytes.Buffer{}
-	if err := m.msi.namespaceTemplate.Execute(namespaceBuf, templateData); err != nil {
+	bindingSelectorBuf := &bytes.Buffer{}
+	if err := m.msi.bindingSelectorTemplate.Execute(bindingSelectorBuf, templateData); err != nil {
 		m.Logger.Panic(err)
 	}
-	if val := namespaceBuf.String(); val != "" {
-		for _, namespace := range strings.Split(val, ",") {
-			namespace = strings.ToLower(strings.TrimSpace(namespace))
+	if val := strings.TrimSpace(bindingSelectorBuf.String()); val != "" {
+		msiInfo.KubernetesBindingSelector = &val
+	}
 
-			if contains(m.Conf.Kubernetes.NamespaceIgnore, namespace) {
-				continue
-			}
+	msiInfo.KubernetesNamespace, msiInfo.KubernetesNamespaceIgnored, err = m.renderNamespaces(m.msi.namespaceTemplate, templateData)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+
+	clusterBuf := &bytes.Buffer{}
+	if err := m.msi.clusterTemplate.Execute(clusterBuf, templateData); err != nil {
+		m.Logger.Panic(err)
+	}
+	msiInfo.KubernetesClusters = splitTemplateList(clusterBuf.String())
 
-			msiInfo.KubernetesNamespace = append(
-				msiInfo.KubernetesNamespace,
-				strings.TrimSpace(namespace),
-			)
+	return
+}
+
+// newMsiTemplateData returns the data of an Azure MSI for the Golang templates rendered for the cluster
+func newMsiTemplateData(msi *msi.Identity, cluster string) (msiTemplateData, error) {
+	resourceInfo, err := azure.ParseResourceID(to.String(msi.ID))
+	if err != nil {
+		return msiTemplateData{}, err
+	}
+
+	return msiTemplateData{
+		Id:             to.String(msi.ID),
+		Name:           to.String(msi.Name),
+		Location:       to.String(msi.Location),
+		ResourceGroup:  resourceInfo.ResourceGroup,
+		SubscriptionId: resourceInfo.SubscriptionID,
+		ClientId:       msi.ClientID.String(),
+		TenantId:       msi.TenantID.String(),
+		PrincipalID:    msi.PrincipalID.String(),
+		Tags:           to.StringMap(msi.Tags),
+		Type:           to.String(msi.Type),
+		Cluster:        cluster,
+		resourceName:   resourceInfo.ResourceName,
+	}, nil
+}
+
+// renderNamespaces renders the namespace template, namespaces not allowed by the namespace filter are returned as ignored
+func (m *MsiOperator) renderNamespaces(t *template.Template, templateData msiTemplateData) (namespaces, ignored []string, err error) {
+	namespaceBuf := &bytes.Buffer{}
+	if err := t.Execute(namespaceBuf, templateData); err != nil {
+		return nil, nil, err
+	}
+
+	for _, namespace := range splitTemplateList(namespaceBuf.String()) {
+		if !m.kubernetes.namespaceMatcher.IsAllowed(namespace) {
+			ignored = append(ignored, namespace)
+			continue
 		}
+
+		namespaces = append(namespaces, namespace)
 	}
+	return
+}
 
+// splitTemplateList splits a rendered template into a list of lowercase values (comma separated)
+func splitTemplateList(val string) (ret []string) {
+	for _, item := range strings.Split(val, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			ret = append(ret, item)
+		}
+	}
 	return
 }
 
-func (m *MsiOperator) applyMsiToK8sObject(msi *msi.Identity, k8sResource *unstructured.Unstructured) error {
+func (m *MsiOperator) applyMsiToK8sObject(msiInfo MsiResourceInfo, k8sResource *unstructured.Unstructured) error {
+	msi := msiInfo.Resource
 	msiResourceId := to.String(msi.ID)
 	msiClientId := msi.ClientID.String()
 
@@ -675,30 +1308,55 @@ func (m *MsiOperator) applyMsiToK8sObject(msi *msi.Identity, k8sResource *unstru
 		}
 	}
 
+	// role assignments annotation
+	if err := m.applyMsiRoleAssignmentsToK8sObject(msiInfo, k8sResource); err != nil {
+		return err
+	}
+
 	// labels
-	labelName := m.labelName("subscription")
-	if err := unstructured.SetNestedField(k8sResource.Object, resourceInfo.SubscriptionID, "metadata", "labels", labelName); err != nil {
-		return fmt.Errorf("failed to set metadata.labels[%v] value: %w", labelName, err)
+	if err := unstructured.SetNestedField(k8sResource.Object, K8sManagedByValue, "metadata", "labels", K8sLabelManagedBy); err != nil {
+		return fmt.Errorf("failed to set metadata.labels[%v] value: %w", K8sLabelManagedBy, err)
 	}
 
-	labelName = m.labelName("resourcegroup")
-	if err := unstructured.SetNestedField(k8sResource.Object, resourceInfo.ResourceGroup, "metadata", "labels", labelName); err != nil {
+	return m.applyMsiLabelsToK8sObject(msiResourceId, resourceInfo, k8sResource)
+}
+
+func (m *MsiOperator) applyMsiLabelsToK8sObject(msiResourceId string, resourceInfo azure.Resource, k8sResource *unstructured.Unstructured) error {
+	labelName := m.labelName("alias")
+	if err := unstructured.SetNestedField(k8sResource.Object, msiResourceAlias(msiResourceId), "metadata", "labels", labelName); err != nil {
 		return fmt.Errorf("failed to set metadata.labels[%v] value: %w", labelName, err)
 	}
 
-	labelName = m.labelName("name")
-	if err := unstructured.SetNestedField(k8sResource.Object, resourceInfo.ResourceName, "metadata", "labels", labelName); err != nil {
+	labelName = m.labelName("subscription")
+	if err := unstructured.SetNestedField(k8sResource.Object, resourceInfo.SubscriptionID, "metadata", "labels", labelName); err != nil {
 		return fmt.Errorf("failed to set metadata.labels[%v] value: %w", labelName, err)
 	}
 
+	// resource group (max 90 chars) and MSI names (max 128 chars) might exceed label value limits,
+	// invalid values are not set (lookup via annotation or alias label)
+	labels := map[string]string{
+		m.labelName("resourcegroup"): resourceInfo.ResourceGroup,
+		m.labelName("name"):          resourceInfo.ResourceName,
+	}
+	for labelName, labelValue := range labels {
+		if validationErrors := validation.IsValidLabelValue(labelValue); len(validationErrors) != 0 {
+			unstructured.RemoveNestedField(k8sResource.Object, "metadata", "labels", labelName)
+			continue
+		}
+
+		if err := unstructured.SetNestedField(k8sResource.Object, labelValue, "metadata", "labels", labelName); err != nil {
+			return fmt.Errorf("failed to set metadata.labels[%v] value: %w", labelName, err)
+		}
+	}
+
 	return nil
 }
 
-func (m *MsiOperator) fetchAzureMsiList(subscription *subscriptions.Subscription) (ret []*msi.Identity, err error) {
+func (m *MsiOperator) fetchAzureMsiList(ctx context.Context, subscription *subscriptions.Subscription) (ret []*msi.Identity, err error) {
 	client := msi.NewUserAssignedIdentitiesClientWithBaseURI(m.azure.environment.ResourceManagerEndpoint, *subscription.SubscriptionID)
 	m.decorateAzureClient(&client.Client)
 
-	list, azureErr := client.ListBySubscriptionComplete(m.ctx)
+	list, azureErr := client.ListBySubscriptionComplete(ctx)
 	if azureErr != nil {
 		err = azureErr
 		return
@@ -707,7 +1365,7 @@ func (m *MsiOperator) fetchAzureMsiList(subscription *subscriptions.Subscription
 	for list.NotDone() {
 		result := list.Value()
 		ret = append(ret, &result)
-		if list.NextWithContext(m.ctx) != nil {
+		if list.NextWithContext(ctx) != nil {
 			break
 		}
 	}
@@ -715,6 +1373,14 @@ func (m *MsiOperator) fetchAzureMsiList(subscription *subscriptions.Subscription
 	return
 }
 
+// kubernetesWriteConcurrency returns the number of concurrent upserts of Azure MSIs (at least 1)
+func (m *MsiOperator) kubernetesWriteConcurrency() int {
+	if m.Conf.Kubernetes.WriteConcurrency < 1 {
+		return 1
+	}
+	return m.Conf.Kubernetes.WriteConcurrency
+}
+
 func (m *MsiOperator) labelName(name string) string {
 	return fmt.Sprintf(m.Conf.Kubernetes.LabelFormat, name)
 }
@@ -0,0 +1,262 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/msi/mgmt/msi"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/gofrs/uuid"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var testAzureIdentityGvr = schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
+
+// testSyncMsiResourceInfo returns an Azure MSI which can be synced to the namespaces
+func testSyncMsiResourceInfo(name string, namespaces ...string) MsiResourceInfo {
+	resourceId := "/subscriptions/00000000-0000-0000-0000-000000000001/resourcegroups/rg/providers/microsoft.managedidentity/userassignedidentities/" + name
+	clientId := uuid.NewV5(uuid.NamespaceURL, name)
+	principalId := uuid.NewV5(uuid.NamespaceOID, name)
+	tenantId := uuid.NewV5(uuid.NamespaceDNS, "tenant")
+
+	return MsiResourceInfo{
+		Resource: &msi.Identity{
+			ID:   to.StringPtr(resourceId),
+			Name: to.StringPtr(name),
+			UserAssignedIdentityProperties: &msi.UserAssignedIdentityProperties{
+				ClientID:    &clientId,
+				PrincipalID: &principalId,
+				TenantID:    &tenantId,
+			},
+		},
+		AzureResourceId:        to.StringPtr(resourceId),
+		AzureResourceName:      to.StringPtr(name),
+		AzureResourceGroup:     to.StringPtr("rg"),
+		AzureSubscriptionId:    to.StringPtr("00000000-0000-0000-0000-000000000001"),
+		KubernetesResourceName: to.StringPtr(name),
+		KubernetesNamespace:    namespaces,
+	}
+}
+
+// newUpsertTestOperator returns an operator with Azure MSIs synced concurrently to multiple namespaces
+func newUpsertTestOperator(objects ...runtime.Object) *MsiOperator {
+	m := newTestOperator(objects...)
+	m.Conf.AzureIdentity.Binding.Sync = false
+	for i := 0; i < 10; i++ {
+		m.serviceDiscovery.msi.Add(testSyncMsiResourceInfo(fmt.Sprintf("msi-%d", i), "team-a", "team-b", "team-c"))
+	}
+	m.serviceDiscovery.msi.Commit()
+	return m
+}
+
+// testUpsertCluster runs upsertCluster for all namespaces and returns the recorded sync run
+func testUpsertCluster(t *testing.T, m *MsiOperator) *SyncRun {
+	t.Helper()
+
+	m.history.begin(SyncRunTriggerManual)
+	err := m.upsertCluster(context.Background(), m.kubernetes.cluster, newNamespaceFilter(nil), m.serviceDiscovery.msi.Version(), true, false)
+	run := m.history.end(err, m.serviceDiscovery.msi.Version())
+	if err != nil {
+		t.Fatalf("upsert failed: %v (errors: %v)", err, run.ErrorMessages)
+	}
+	return run
+}
+
+func testAssertAzureIdentities(t *testing.T, m *MsiOperator, expected int) {
+	t.Helper()
+
+	list, err := m.kubernetes.client.Resource(testAzureIdentityGvr).List(context.Background(), metav1.ListOptions{
+		LabelSelector: K8sLabelManagedBy + "=" + K8sManagedByValue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != expected {
+		t.Errorf("expected %d managed AzureIdentities, got %d", expected, len(list.Items))
+	}
+}
+
+func TestUpsertClusterCached(t *testing.T) {
+	m := newUpsertTestOperator()
+
+	run := testUpsertCluster(t, m)
+	if run.Created != 30 || run.Updated != 0 || run.Unchanged != 0 {
+		t.Errorf("first upsert: expected 30 created, got %+v", run)
+	}
+	testAssertAzureIdentities(t, m, 30)
+
+	// existing AzureIdentities are compared using the cache, no Get per AzureIdentity
+	client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+	client.ClearActions()
+	run = testUpsertCluster(t, m)
+	if run.Created != 0 || run.Updated != 0 || run.Unchanged != 30 {
+		t.Errorf("second upsert: expected 30 unchanged, got %+v", run)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "list" {
+			t.Errorf("second upsert: expected only list calls, got %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestUpsertClusterUncached(t *testing.T) {
+	m := newUpsertTestOperator()
+	client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+	client.PrependReactor("list", K8sSchemeAzureIdentityResourcePlural, func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(testAzureIdentityGvr.GroupResource(), "", fmt.Errorf("list not allowed"))
+	})
+
+	// AzureIdentities are fetched one by one
+	run := testUpsertCluster(t, m)
+	if run.Created != 30 {
+		t.Errorf("first upsert: expected 30 created, got %+v", run)
+	}
+
+	run = testUpsertCluster(t, m)
+	if run.Created != 0 || run.Unchanged != 30 {
+		t.Errorf("second upsert: expected 30 unchanged, got %+v", run)
+	}
+
+	gets := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetResource() == testAzureIdentityGvr {
+			gets++
+		}
+	}
+	if gets != 60 {
+		t.Errorf("expected a get per AzureIdentity and upsert (60), got %d", gets)
+	}
+}
+
+func TestUpsertClusterAlreadyExists(t *testing.T) {
+	// AzureIdentities without managed-by label (eg. created by older versions) are not cached
+	objects := []runtime.Object{}
+	for i := 0; i < 10; i++ {
+		objects = append(objects, testAzureIdentity("team-b", fmt.Sprintf("msi-%d", i), map[string]interface{}{}))
+	}
+	m := newUpsertTestOperator(objects...)
+
+	run := testUpsertCluster(t, m)
+	if run.Created != 20 || run.Updated != 10 {
+		t.Errorf("expected 20 created and 10 updated (create fallback), got %+v", run)
+	}
+	testAssertAzureIdentities(t, m, 30)
+
+	run = testUpsertCluster(t, m)
+	if run.Unchanged != 30 {
+		t.Errorf("second upsert: expected 30 unchanged, got %+v", run)
+	}
+}
+
+// startTestRun starts a run in the background, fn is called with the run context after the run has started
+func startTestRun(m *MsiOperator, fn func(ctx context.Context) error) chan error {
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- m.run(context.Background(), SyncScheduleReconcile, func(ctx context.Context) error {
+			close(started)
+			return fn(ctx)
+		})
+	}()
+	<-started
+	return done
+}
+
+func TestShutdownDrainsRun(t *testing.T) {
+	m := newTestOperator()
+	m.health.setLeader(true)
+
+	finish := make(chan struct{})
+	done := startTestRun(m, func(ctx context.Context) error {
+		<-finish
+		return ctx.Err()
+	})
+
+	shutdown := make(chan struct{})
+	go func() {
+		m.Shutdown(time.Minute)
+		close(shutdown)
+	}()
+
+	// shutdown waits for the running run
+	select {
+	case <-shutdown:
+		t.Fatal("expected shutdown to wait for running run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(finish)
+	if err := <-done; err != nil {
+		t.Errorf("expected run to finish without cancellation, got %v", err)
+	}
+	<-shutdown
+
+	if runs := m.history.list(); len(runs) != 1 || !runs[0].Success {
+		t.Errorf("expected finished run in history, got %+v", runs)
+	}
+
+	// no runs after shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	called := false
+	if err := m.run(ctx, SyncScheduleReconcile, func(ctx context.Context) error { called = true; return nil }); err != nil || called {
+		t.Errorf("expected no run after shutdown, got %v (called: %v)", err, called)
+	}
+}
+
+func TestShutdownCancelsRun(t *testing.T) {
+	m := newTestOperator()
+	m.health.setLeader(true)
+
+	done := startTestRun(m, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// API calls of runs are cancelled after the shutdown timeout
+	m.Shutdown(50 * time.Millisecond)
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancelled run, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected run to be cancelled on shutdown")
+	}
+}
+
+func TestShutdownReleasesLeadership(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Lease.Enabled = true
+	m.health.setLeader(true)
+
+	// leader election releasing the lease when cancelled (ReleaseOnCancel)
+	released := false
+	m.leaderElection.ctx, m.leaderElection.cancel = context.WithCancel(context.Background())
+	m.leaderElection.done = make(chan struct{})
+	go func() {
+		<-m.leaderElection.ctx.Done()
+		released = true
+		close(m.leaderElection.done)
+	}()
+
+	m.Shutdown(time.Second)
+
+	if !released {
+		t.Errorf("expected leader election to be stopped and lease to be released")
+	}
+	if ok, _ := m.CheckLeadership(); ok {
+		t.Errorf("expected no leadership after shutdown")
+	}
+	if m.stopCtx.Err() == nil {
+		t.Errorf("expected sync loops to be stopped")
+	}
+}
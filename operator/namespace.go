@@ -0,0 +1,90 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// behaviour if target namespace is missing
+	NamespaceMissingSkip   = "skip"
+	NamespaceMissingError  = "error"
+	NamespaceMissingCreate = "create"
+
+	K8sLabelManagedBy = "app.kubernetes.io/managed-by"
+	K8sManagedByValue = "azure-msi-operator"
+)
+
+// checkKubernetesNamespace checks if the target namespace exists and creates it if configured
+// returns false if the namespace doesn't exist and should be skipped
+func (m *MsiOperator) checkKubernetesNamespace(ctx context.Context, cluster *kubernetesCluster, contextLogger *zap.SugaredLogger, msiResource MsiResourceInfo, k8sNamespace string) (bool, error) {
+	if m.Conf.Kubernetes.NamespaceMissing == NamespaceMissingError {
+		// no check, errors are reported by AzureIdentity sync
+		return true, nil
+	}
+
+	gvr := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+	_, err := cluster.client.Resource(gvr).Get(ctx, k8sNamespace, metav1.GetOptions{})
+	if err == nil {
+		return true, nil
+	} else if !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to fetch Namespace \"%s\": %w", k8sNamespace, err)
+	}
+
+	if m.Conf.Kubernetes.NamespaceMissing != NamespaceMissingCreate {
+		contextLogger.Infof("skipping missing Namespace \"%s\"", k8sNamespace)
+		return false, nil
+	}
+
+	// create
+	contextLogger.Infof("creating Namespace \"%s\"", k8sNamespace)
+
+	labels := map[string]interface{}{}
+	for key, val := range m.Conf.Kubernetes.NamespaceCreateLabels {
+		labels[key] = val
+	}
+	labels[K8sLabelManagedBy] = K8sManagedByValue
+
+	annotations := map[string]interface{}{}
+	for key, val := range m.Conf.Kubernetes.NamespaceCreateAnnotations {
+		annotations[key] = val
+	}
+	annotations[m.labelName("resourceid")] = to.String(msiResource.AzureResourceId)
+
+	namespaceObj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name":        k8sNamespace,
+				"labels":      labels,
+				"annotations": annotations,
+			},
+		},
+	}
+
+	subscriptionId := to.String(msiResource.AzureSubscriptionId)
+	_, err = cluster.client.Resource(gvr).Create(ctx, namespaceObj, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		// created in the meantime
+		return true, nil
+	}
+	m.auditChange(ctx, AuditActionCreate, msiResource, "Namespace", "", k8sNamespace, nil, namespaceObj, err)
+	if err != nil {
+		m.prometheus.msiResourceErrors.WithLabelValues(subscriptionId, "Namespace").Inc()
+		m.history.count(SyncResultError)
+		return false, fmt.Errorf("failed to create Namespace \"%s\": %w", k8sNamespace, err)
+	}
+	m.prometheus.msiResourceSuccess.WithLabelValues(subscriptionId, "Namespace").Inc()
+	m.history.count(SyncResultCreated)
+
+	return true, nil
+}
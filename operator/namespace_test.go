@@ -0,0 +1,110 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var testNamespaceGvr = schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+func testNamespace(name string, labels map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name":   name,
+				"labels": labels,
+			},
+		},
+	}
+}
+
+func TestCheckKubernetesNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		namespace string
+		getErr    error
+		ok        bool
+		err       bool
+		created   bool
+	}{
+		{"error mode, no check", NamespaceMissingError, "missing", nil, true, false, false},
+		{"skip existing", NamespaceMissingSkip, "team-a", nil, true, false, false},
+		{"skip missing", NamespaceMissingSkip, "missing", nil, false, false, false},
+		{"create existing", NamespaceMissingCreate, "team-a", nil, true, false, false},
+		{"create missing", NamespaceMissingCreate, "missing", nil, true, false, true},
+		{"fetch failed", NamespaceMissingCreate, "missing", apierrors.NewForbidden(testNamespaceGvr.GroupResource(), "missing", fmt.Errorf("get not allowed")), false, true, false},
+	}
+
+	for _, test := range tests {
+		m := newTestOperator(testNamespace("team-a", nil))
+		m.Conf.Kubernetes.NamespaceMissing = test.mode
+		m.Conf.Kubernetes.NamespaceCreateLabels = map[string]string{"team": "a"}
+		m.Conf.Kubernetes.NamespaceCreateAnnotations = map[string]string{"owner": "platform"}
+		client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+		if test.getErr != nil {
+			getErr := test.getErr
+			client.PrependReactor("get", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, getErr
+			})
+		}
+
+		msiInfo := testSyncMsiResourceInfo("foo", test.namespace)
+		ok, err := m.checkKubernetesNamespace(context.Background(), m.kubernetes.cluster, m.Logger, msiInfo, test.namespace)
+		if ok != test.ok || (err != nil) != test.err {
+			t.Errorf("%s: expected ok=%v err=%v, got %v %v", test.name, test.ok, test.err, ok, err)
+		}
+
+		created := false
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "create" {
+				created = true
+			}
+		}
+		if created != test.created {
+			t.Errorf("%s: expected created=%v, got %v", test.name, test.created, created)
+		}
+		if !test.created {
+			continue
+		}
+
+		namespaceObj, err := client.Resource(testNamespaceGvr).Get(context.Background(), test.namespace, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expectedLabels := map[string]string{"team": "a", K8sLabelManagedBy: K8sManagedByValue}
+		if !reflect.DeepEqual(namespaceObj.GetLabels(), expectedLabels) {
+			t.Errorf("%s: expected labels %v, got %v", test.name, expectedLabels, namespaceObj.GetLabels())
+		}
+		expectedAnnotations := map[string]string{"owner": "platform", "msi.azure.k8s.io/resourceid": *msiInfo.AzureResourceId}
+		if !reflect.DeepEqual(namespaceObj.GetAnnotations(), expectedAnnotations) {
+			t.Errorf("%s: expected annotations %v, got %v", test.name, expectedAnnotations, namespaceObj.GetAnnotations())
+		}
+	}
+}
+
+func TestCheckKubernetesNamespaceCreatedInMeantime(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Kubernetes.NamespaceMissing = NamespaceMissingCreate
+	client := m.kubernetes.client.(*dynamicfake.FakeDynamicClient)
+	client.PrependReactor("create", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewAlreadyExists(testNamespaceGvr.GroupResource(), "missing")
+	})
+
+	ok, err := m.checkKubernetesNamespace(context.Background(), m.kubernetes.cluster, m.Logger, testSyncMsiResourceInfo("foo", "missing"), "missing")
+	if !ok || err != nil {
+		t.Errorf("expected namespace created in the meantime to be used, got %v %v", ok, err)
+	}
+}
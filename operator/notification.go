@@ -0,0 +1,369 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// notification events
+	NotificationEventFailed  = "failed"
+	NotificationEventGranted = "granted"
+
+	// notifier types
+	NotifierTypeWebhook = "webhook"
+	NotifierTypeSlack   = "slack"
+	NotifierTypeTeams   = "teams"
+
+	// notification status (metric label)
+	NotificationStatusSent       = "sent"
+	NotificationStatusFailed     = "failed"
+	NotificationStatusDropped    = "dropped"
+	NotificationStatusSuppressed = "suppressed"
+
+	// max number of queued notifications
+	notificationQueueSize = 100
+)
+
+type (
+	// Notification is an event of the sync of an Azure MSI which is sent to the notifiers
+	Notification struct {
+		Event               string    `json:"event"`
+		Message             string    `json:"message"`
+		Time                time.Time `json:"time"`
+		Kind                string    `json:"kind"`
+		Cluster             string    `json:"cluster,omitempty"`
+		Namespace           string    `json:"namespace"`
+		Name                string    `json:"name"`
+		AzureResourceId     string    `json:"azureResourceId"`
+		AzureResourceName   string    `json:"azureResourceName"`
+		AzureSubscriptionId string    `json:"azureSubscriptionId"`
+		Trigger             string    `json:"trigger"`
+		Error               string    `json:"error,omitempty"`
+	}
+
+	// Notifier sends notifications to an external system
+	Notifier interface {
+		Type() string
+		Notify(ctx context.Context, notification Notification) error
+	}
+
+	// webhookNotifier sends the notification as JSON payload
+	webhookNotifier struct {
+		client *http.Client
+		url    string
+	}
+
+	// slackNotifier sends the message to a Slack compatible incoming webhook
+	slackNotifier struct {
+		client *http.Client
+		url    string
+	}
+
+	// teamsNotifier sends the message as MessageCard to a Microsoft Teams incoming webhook
+	teamsNotifier struct {
+		client *http.Client
+		url    string
+	}
+
+	// notificationDispatcher renders, rate limits and sends notifications asynchronously
+	notificationDispatcher struct {
+		logger         *zap.SugaredLogger
+		notifiers      []Notifier
+		events         map[string]bool
+		templates      map[string]*template.Template
+		limiter        *rate.Limiter
+		repeatInterval time.Duration
+		timeout        time.Duration
+		metric         *prometheus.CounterVec
+
+		lock     sync.Mutex
+		closed   bool
+		lastSent map[string]time.Time
+		// last prune of expired lastSent entries
+		lastPruned time.Time
+		queue      chan Notification
+		done       chan struct{}
+	}
+)
+
+func (m *MsiOperator) initNotification() {
+	notifiers := []Notifier{}
+	for _, target := range m.Conf.Notification.Targets {
+		notifier, err := newNotifier(target, m.Conf.Notification.Timeout)
+		if err != nil {
+			m.Logger.Panic(err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	if len(notifiers) == 0 {
+		return
+	}
+
+	templates := map[string]*template.Template{}
+	for event, val := range map[string]string{
+		NotificationEventFailed:  m.Conf.Notification.TemplateFailed,
+		NotificationEventGranted: m.Conf.Notification.TemplateGranted,
+	} {
+		t, err := template.New("notification" + event).Parse(val)
+		if err != nil {
+			m.Logger.Panic(err)
+		}
+		templates[event] = t
+	}
+
+	events := map[string]bool{}
+	for _, event := range m.Conf.Notification.Events {
+		events[event] = true
+	}
+
+	limit := rate.Inf
+	if m.Conf.Notification.RateLimit > 0 {
+		limit = rate.Every(time.Minute / time.Duration(m.Conf.Notification.RateLimit))
+	}
+
+	m.notification = &notificationDispatcher{
+		logger:         m.Logger,
+		notifiers:      notifiers,
+		events:         events,
+		templates:      templates,
+		limiter:        rate.NewLimiter(limit, m.Conf.Notification.RateLimit),
+		repeatInterval: m.Conf.Notification.RepeatInterval,
+		timeout:        m.Conf.Notification.Timeout,
+		metric:         m.prometheus.notifications,
+		lastSent:       map[string]time.Time{},
+		queue:          make(chan Notification, notificationQueueSize),
+		done:           make(chan struct{}),
+	}
+	m.notification.start(m.ctx)
+
+	m.Logger.Infof("sending notifications (%v) to %d targets", strings.Join(m.Conf.Notification.Events, ", "), len(notifiers))
+}
+
+// newNotifier creates a notifier from a target (type=url)
+func newNotifier(target string, timeout time.Duration) (Notifier, error) {
+	notifierType, url, found := strings.Cut(target, "=")
+	if !found || url == "" {
+		// target is not logged as the url usually contains a secret
+		return nil, fmt.Errorf("invalid notification target, expected type=url")
+	}
+
+	client := &http.Client{Timeout: timeout}
+	switch strings.ToLower(notifierType) {
+	case NotifierTypeWebhook:
+		return &webhookNotifier{client: client, url: url}, nil
+	case NotifierTypeSlack:
+		return &slackNotifier{client: client, url: url}, nil
+	case NotifierTypeTeams:
+		return &teamsNotifier{client: client, url: url}, nil
+	default:
+		return nil, fmt.Errorf("invalid notification target type \"%s\" (webhook, slack, teams)", notifierType)
+	}
+}
+
+// shutdownNotification sends queued notifications (until ctx is cancelled)
+func (m *MsiOperator) shutdownNotification(ctx context.Context) {
+	if m.notification == nil {
+		return
+	}
+
+	if err := m.notification.close(ctx); err != nil {
+		m.Logger.Warnf("failed to send queued notifications: %v", err)
+	}
+}
+
+// notify queues a notification for a Kubernetes resource of an Azure MSI
+func (m *MsiOperator) notify(ctx context.Context, event string, msiInfo MsiResourceInfo, kind, namespace, name string, err error) {
+	if m.notification == nil {
+		return
+	}
+
+	notification := Notification{
+		Event:               event,
+		Time:                time.Now().UTC(),
+		Kind:                kind,
+		Cluster:             clusterFromContext(ctx),
+		Namespace:           namespace,
+		Name:                name,
+		AzureResourceId:     to.String(msiInfo.AzureResourceId),
+		AzureResourceName:   to.String(msiInfo.AzureResourceName),
+		AzureSubscriptionId: to.String(msiInfo.AzureSubscriptionId),
+		Trigger:             syncTriggerFromContext(ctx),
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+
+	m.notification.dispatch(notification)
+}
+
+// dispatch renders the message and queues the notification, repeated and rate limited notifications are dropped
+func (d *notificationDispatcher) dispatch(notification Notification) {
+	if !d.events[notification.Event] {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	d.pruneLastSent(time.Now())
+
+	key := strings.Join([]string{notification.Event, notification.AzureResourceId, notification.Kind, notification.Cluster, notification.Namespace, notification.Name}, "|")
+	if lastSent, exists := d.lastSent[key]; exists && time.Since(lastSent) < d.repeatInterval {
+		d.metric.WithLabelValues("", NotificationStatusSuppressed).Inc()
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := d.templates[notification.Event].Execute(buf, notification); err != nil {
+		d.logger.Errorf("failed to render %s notification: %v", notification.Event, err)
+		return
+	}
+	notification.Message = buf.String()
+
+	if !d.limiter.Allow() {
+		d.logger.Warnf("notification rate limit exceeded, dropping notification: %s", notification.Message)
+		d.metric.WithLabelValues("", NotificationStatusDropped).Inc()
+		return
+	}
+
+	select {
+	case d.queue <- notification:
+		d.lastSent[key] = time.Now()
+	default:
+		d.logger.Warnf("notification queue is full, dropping notification: %s", notification.Message)
+		d.metric.WithLabelValues("", NotificationStatusDropped).Inc()
+	}
+}
+
+// pruneLastSent removes entries older than the repeat interval (at most once per repeat interval), lock must be held by caller
+func (d *notificationDispatcher) pruneLastSent(now time.Time) {
+	if now.Sub(d.lastPruned) < d.repeatInterval {
+		return
+	}
+	d.lastPruned = now
+
+	for key, lastSent := range d.lastSent {
+		if now.Sub(lastSent) >= d.repeatInterval {
+			delete(d.lastSent, key)
+		}
+	}
+}
+
+func (d *notificationDispatcher) start(ctx context.Context) {
+	go func() {
+		defer close(d.done)
+		for notification := range d.queue {
+			for _, notifier := range d.notifiers {
+				notifyCtx, cancel := context.WithTimeout(ctx, d.timeout)
+				if err := notifier.Notify(notifyCtx, notification); err != nil {
+					d.logger.Errorf("failed to send notification to %s: %v", notifier.Type(), err)
+					d.metric.WithLabelValues(notifier.Type(), NotificationStatusFailed).Inc()
+				} else {
+					d.metric.WithLabelValues(notifier.Type(), NotificationStatusSent).Inc()
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+func (d *notificationDispatcher) close(ctx context.Context) error {
+	d.lock.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.lock.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *webhookNotifier) Type() string {
+	return NotifierTypeWebhook
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	return postJson(ctx, n.client, n.url, notification)
+}
+
+func (n *slackNotifier) Type() string {
+	return NotifierTypeSlack
+}
+
+func (n *slackNotifier) Notify(ctx context.Context, notification Notification) error {
+	return postJson(ctx, n.client, n.url, map[string]interface{}{
+		"text": notification.Message,
+	})
+}
+
+func (n *teamsNotifier) Type() string {
+	return NotifierTypeTeams
+}
+
+func (n *teamsNotifier) Notify(ctx context.Context, notification Notification) error {
+	themeColor := "2EB886"
+	if notification.Event == NotificationEventFailed {
+		themeColor = "D40E0D"
+	}
+
+	return postJson(ctx, n.client, n.url, map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": themeColor,
+		"summary":    notification.Message,
+		"text":       notification.Message,
+	})
+}
+
+// postJson sends the payload as JSON via POST, non 2xx responses are returned as error
+func postJson(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
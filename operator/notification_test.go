@@ -0,0 +1,116 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+func TestNewNotifier(t *testing.T) {
+	testCases := map[string]string{
+		"webhook=https://example.com/hook?a=b": NotifierTypeWebhook,
+		"slack=https://hooks.slack.com/xxx":    NotifierTypeSlack,
+		"Teams=https://example.com/teams":      NotifierTypeTeams,
+	}
+
+	for target, expected := range testCases {
+		notifier, err := newNotifier(target, time.Second)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", target, err)
+			continue
+		}
+		if notifier.Type() != expected {
+			t.Errorf("%s: expected type %s, got %s", target, expected, notifier.Type())
+		}
+	}
+
+	for _, target := range []string{"slack", "slack=", "mail=foo@example.com"} {
+		if _, err := newNotifier(target, time.Second); err == nil {
+			t.Errorf("%s: expected error", target)
+		}
+	}
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	lock := sync.Mutex{}
+	messages := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		lock.Lock()
+		messages = append(messages, payload["text"])
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	d := &notificationDispatcher{
+		logger:    zap.NewNop().Sugar(),
+		notifiers: []Notifier{&slackNotifier{client: server.Client(), url: server.URL}},
+		events:    map[string]bool{NotificationEventFailed: true},
+		templates: map[string]*template.Template{
+			NotificationEventFailed: template.Must(template.New("failed").Parse("{{ .AzureResourceName }} failed in {{ .Namespace }}")),
+		},
+		limiter:        rate.NewLimiter(rate.Every(time.Minute), 2),
+		repeatInterval: time.Hour,
+		timeout:        time.Second,
+		metric:         prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test"}, []string{"notifier", "status"}),
+		lastSent:       map[string]time.Time{},
+		queue:          make(chan Notification, notificationQueueSize),
+		done:           make(chan struct{}),
+	}
+	d.start(context.Background())
+
+	for _, namespace := range []string{"ns1", "ns1", "ns2", "ns3"} {
+		d.dispatch(Notification{Event: NotificationEventFailed, AzureResourceName: "msi", Namespace: namespace})
+	}
+	// event not enabled
+	d.dispatch(Notification{Event: NotificationEventGranted, AzureResourceName: "msi", Namespace: "ns4"})
+
+	if err := d.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// ns1 is repeated, ns3 is rate limited
+	if len(messages) != 2 || messages[0] != "msi failed in ns1" || messages[1] != "msi failed in ns2" {
+		t.Errorf("unexpected messages %v", messages)
+	}
+}
+
+func TestNotificationDispatcherPruneLastSent(t *testing.T) {
+	now := time.Now()
+	d := &notificationDispatcher{
+		repeatInterval: time.Hour,
+		lastSent: map[string]time.Time{
+			"expired": now.Add(-2 * time.Hour),
+			"recent":  now.Add(-time.Minute),
+		},
+	}
+
+	d.pruneLastSent(now)
+	if _, exists := d.lastSent["expired"]; exists || len(d.lastSent) != 1 {
+		t.Errorf("expected only recent entry, got %v", d.lastSent)
+	}
+
+	// pruned at most once per repeat interval
+	d.lastSent["expired"] = now.Add(-2 * time.Hour)
+	d.pruneLastSent(now.Add(time.Minute))
+	if len(d.lastSent) != 2 {
+		t.Errorf("expected no prune within repeat interval, got %v", d.lastSent)
+	}
+
+	d.pruneLastSent(now.Add(2 * time.Hour))
+	if len(d.lastSent) != 0 {
+		t.Errorf("expected all entries pruned, got %v", d.lastSent)
+	}
+}
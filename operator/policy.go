@@ -0,0 +1,328 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	MsiPolicyActionAllow = "allow"
+	MsiPolicyActionDeny  = "deny"
+
+	// rule name of denials by default action
+	MsiPolicyRuleDefault = "default"
+)
+
+type (
+	// MsiPolicy decides which Azure MSIs may be synced to which namespaces
+	//
+	// the first rule matching the Azure MSI decides, if no rule matches the default action is used
+	MsiPolicy struct {
+		DefaultAction string          `json:"defaultAction"`
+		Rules         []MsiPolicyRule `json:"rules"`
+	}
+
+	// MsiPolicyRule restricts the namespaces of the matching Azure MSIs
+	MsiPolicyRule struct {
+		Name  string         `json:"name"`
+		Match MsiPolicyMatch `json:"match"`
+
+		// deny all namespaces (eg. for exceptions before broader rules)
+		Deny bool `json:"deny"`
+
+		// allowed namespaces (glob or /regexp/, all namespaces if empty)
+		Namespaces []string `json:"namespaces"`
+
+		// required namespace labels, values are Golang templates using the Azure MSI template data
+		NamespaceLabels map[string]string `json:"namespaceLabels"`
+
+		namespaceMatcher *NamespaceMatcher
+		labelTemplates   map[string]*template.Template
+	}
+
+	// MsiPolicyMatch selects the Azure MSIs of a rule (globs, all Azure MSIs if empty)
+	MsiPolicyMatch struct {
+		Subscriptions  []string          `json:"subscriptions"`
+		ResourceGroups []string          `json:"resourceGroups"`
+		Names          []string          `json:"names"`
+		Types          []string          `json:"types"`
+		Clusters       []string          `json:"clusters"`
+		Tags           map[string]string `json:"tags"`
+	}
+
+	// MsiPolicyDecision is the result of the policy evaluation of an Azure MSI and a namespace
+	MsiPolicyDecision struct {
+		Allowed bool
+		Rule    string
+		Reason  string
+	}
+)
+
+// LoadMsiPolicy reads and validates the policy file (YAML or JSON)
+func LoadMsiPolicy(file string) (*MsiPolicy, error) {
+	content, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	return ParseMsiPolicy(content)
+}
+
+// ParseMsiPolicy parses and validates the policy (YAML or JSON)
+func ParseMsiPolicy(content []byte) (*MsiPolicy, error) {
+	policy := &MsiPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	switch policy.DefaultAction {
+	case "":
+		// secure default, Azure MSIs which are not matched by a rule are denied
+		policy.DefaultAction = MsiPolicyActionDeny
+	case MsiPolicyActionAllow, MsiPolicyActionDeny:
+	default:
+		return nil, fmt.Errorf("invalid policy defaultAction \"%s\" (allow or deny)", policy.DefaultAction)
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+
+		if err := rule.Match.validate(); err != nil {
+			return nil, fmt.Errorf("invalid policy rule \"%s\": %w", rule.Name, err)
+		}
+
+		var err error
+		if rule.namespaceMatcher, err = NewNamespaceMatcher(rule.Namespaces, nil); err != nil {
+			return nil, fmt.Errorf("invalid policy rule \"%s\": %w", rule.Name, err)
+		}
+
+		rule.labelTemplates = map[string]*template.Template{}
+		for label, value := range rule.NamespaceLabels {
+			if rule.labelTemplates[label], err = template.New(label).Option("missingkey=zero").Parse(value); err != nil {
+				return nil, fmt.Errorf("invalid policy rule \"%s\": namespace label \"%s\": %w", rule.Name, label, err)
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// NeedsNamespaceLabels returns true if any rule requires namespace labels (namespaces are only fetched if needed)
+func (p *MsiPolicy) NeedsNamespaceLabels() bool {
+	for _, rule := range p.Rules {
+		if len(rule.NamespaceLabels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate decides if the Azure MSI may be synced to the namespace
+func (p *MsiPolicy) Evaluate(data msiTemplateData, namespace string, namespaceLabels map[string]string) MsiPolicyDecision {
+	for _, rule := range p.Rules {
+		if !rule.Match.matches(data) {
+			continue
+		}
+
+		return rule.evaluate(data, namespace, namespaceLabels)
+	}
+
+	if p.DefaultAction == MsiPolicyActionAllow {
+		return MsiPolicyDecision{Allowed: true, Rule: MsiPolicyRuleDefault}
+	}
+	return MsiPolicyDecision{Rule: MsiPolicyRuleDefault, Reason: "no policy rule matches the Azure MSI"}
+}
+
+func (r *MsiPolicyRule) evaluate(data msiTemplateData, namespace string, namespaceLabels map[string]string) MsiPolicyDecision {
+	decision := MsiPolicyDecision{Rule: r.Name}
+
+	if r.Deny {
+		decision.Reason = "denied by policy rule"
+		return decision
+	}
+
+	if len(r.Namespaces) > 0 && !r.namespaceMatcher.IsAllowed(namespace) {
+		decision.Reason = fmt.Sprintf("namespace \"%s\" not allowed by policy rule", namespace)
+		return decision
+	}
+
+	for label, t := range r.labelTemplates {
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, data); err != nil {
+			decision.Reason = fmt.Sprintf("failed to render namespace label \"%s\": %v", label, err)
+			return decision
+		}
+
+		// empty values never match, otherwise missing tags would allow unlabeled namespaces
+		expected := strings.TrimSpace(buf.String())
+		if val, exists := namespaceLabels[label]; !exists || expected == "" || val != expected {
+			decision.Reason = fmt.Sprintf("namespace \"%s\" requires label %s=%s", namespace, label, expected)
+			return decision
+		}
+	}
+
+	decision.Allowed = true
+	return decision
+}
+
+func (m *MsiPolicyMatch) validate() error {
+	patterns := [][]string{m.Subscriptions, m.ResourceGroups, m.Names, m.Types, m.Clusters}
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid match pattern \"%s\": %w", pattern, err)
+			}
+		}
+	}
+
+	for tag, pattern := range m.Tags {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid match pattern \"%s\" of tag \"%s\": %w", pattern, tag, err)
+		}
+	}
+	return nil
+}
+
+func (m *MsiPolicyMatch) matches(data msiTemplateData) bool {
+	switch {
+	case !matchPolicyPatterns(m.Subscriptions, data.SubscriptionId),
+		!matchPolicyPatterns(m.ResourceGroups, data.ResourceGroup),
+		!matchPolicyPatterns(m.Names, data.Name),
+		!matchPolicyPatterns(m.Types, data.Type),
+		!matchPolicyPatterns(m.Clusters, data.Cluster):
+		return false
+	}
+
+	for tag, pattern := range m.Tags {
+		val, exists := data.Tags[tag]
+		if !exists {
+			return false
+		}
+
+		// tag values are case sensitive
+		if matched, _ := path.Match(pattern, val); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchPolicyPatterns matches the value (case insensitive) against the globs, empty list matches all values
+func matchPolicyPatterns(patterns []string, val string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	val = strings.ToLower(val)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), val); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// initPolicy loads the policy file (if set)
+func (m *MsiOperator) initPolicy() {
+	if m.Conf.Policy.File == "" {
+		return
+	}
+
+	var err error
+	if m.policy, err = LoadMsiPolicy(m.Conf.Policy.File); err != nil {
+		m.Logger.Panic(err)
+	}
+
+	m.Logger.Infof("using policy \"%s\" with %d rules (default action: %s)", m.Conf.Policy.File, len(m.policy.Rules), m.policy.DefaultAction)
+}
+
+// checkMsiPolicy evaluates the policy for the Azure MSI and namespace, must be called before anything is written to the cluster
+// returns false if the Azure MSI must not be synced to the namespace
+func (m *MsiOperator) checkMsiPolicy(ctx context.Context, cluster *kubernetesCluster, contextLogger *zap.SugaredLogger, msiResource MsiResourceInfo, k8sNamespace string, cache *upsertCache) (bool, error) {
+	decision, err := m.evaluateMsiPolicy(ctx, cluster, msiResource, k8sNamespace, cache)
+	if err != nil {
+		return false, err
+	} else if decision.Allowed {
+		return true, nil
+	}
+
+	resourceId := to.String(msiResource.AzureResourceId)
+
+	contextLogger.Warnf("denied by policy (rule \"%s\"): %s", decision.Rule, decision.Reason)
+	m.history.addError("%s: denied by policy for namespace \"%s\" (rule \"%s\"): %s", resourceId, k8sNamespace, decision.Rule, decision.Reason)
+	m.prometheus.policyDenials.With(prometheus.Labels{
+		"cluster":      cluster.name,
+		"subscription": to.String(msiResource.AzureSubscriptionId),
+		"namespace":    k8sNamespace,
+		"rule":         decision.Rule,
+	}).Inc()
+	m.setMsiDenied(cluster, resourceId, k8sNamespace, fmt.Sprintf("rule \"%s\": %s", decision.Rule, decision.Reason))
+	return false, nil
+}
+
+// evaluateMsiPolicy evaluates the policy for the Azure MSI and namespace without recording denials (webhook and API)
+// namespace labels are fetched once per cache
+func (m *MsiOperator) evaluateMsiPolicy(ctx context.Context, cluster *kubernetesCluster, msiResource MsiResourceInfo, k8sNamespace string, cache *upsertCache) (MsiPolicyDecision, error) {
+	if m.policy == nil {
+		return MsiPolicyDecision{Allowed: true}, nil
+	}
+
+	templateData, err := newMsiTemplateData(msiResource.Resource, cluster.name)
+	if err != nil {
+		return MsiPolicyDecision{}, fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+
+	var namespaceLabels map[string]string
+	if m.policy.NeedsNamespaceLabels() {
+		namespaceCheck := cache.namespaceCheck(k8sNamespace)
+		namespaceCheck.labelsOnce.Do(func() {
+			namespaceCheck.labels, namespaceCheck.labelsErr = m.lookupKubernetesNamespaceLabels(ctx, cluster, k8sNamespace)
+		})
+		if namespaceCheck.labelsErr != nil {
+			return MsiPolicyDecision{}, fmt.Errorf("failed to evaluate policy: %w", namespaceCheck.labelsErr)
+		}
+		namespaceLabels = namespaceCheck.labels
+	}
+
+	return m.policy.Evaluate(templateData, k8sNamespace, namespaceLabels), nil
+}
+
+// lookupKubernetesNamespaceLabels returns the labels of the namespace
+// missing namespaces are evaluated with the labels they would be created with (--kubernetes.namespace.missing=create)
+func (m *MsiOperator) lookupKubernetesNamespaceLabels(ctx context.Context, cluster *kubernetesCluster, k8sNamespace string) (map[string]string, error) {
+	gvr := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}
+
+	namespaceObj, err := cluster.client.Resource(gvr).Get(ctx, k8sNamespace, metav1.GetOptions{})
+	if err == nil {
+		return namespaceObj.GetLabels(), nil
+	} else if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to fetch Namespace \"%s\": %w", k8sNamespace, err)
+	}
+
+	labels := map[string]string{}
+	if m.Conf.Kubernetes.NamespaceMissing == NamespaceMissingCreate {
+		for key, val := range m.Conf.Kubernetes.NamespaceCreateLabels {
+			labels[key] = val
+		}
+		labels[K8sLabelManagedBy] = K8sManagedByValue
+	}
+	return labels, nil
+}
@@ -0,0 +1,152 @@
+package operator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testMsiPolicy = `
+defaultAction: deny
+rules:
+  - name: platform-exception
+    match:
+      resourceGroups: ["rg-platform"]
+      names: ["legacy-*"]
+    deny: true
+  - name: platform
+    match:
+      resourceGroups: ["rg-platform"]
+    namespaces: ["kube-*", "/^platform(-.+)?$/"]
+  - name: teams
+    match:
+      resourceGroups: ["rg-team-*"]
+      tags:
+        team: "*"
+    namespaceLabels:
+      team: '{{ index .Tags "team" }}'
+`
+
+func TestMsiPolicyEvaluate(t *testing.T) {
+	policy, err := ParseMsiPolicy([]byte(testMsiPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.NeedsNamespaceLabels() {
+		t.Fatalf("expected policy to need namespace labels")
+	}
+
+	platform := msiTemplateData{ResourceGroup: "RG-Platform", Name: "dns"}
+	legacy := msiTemplateData{ResourceGroup: "rg-platform", Name: "legacy-dns"}
+	team := msiTemplateData{ResourceGroup: "rg-team-a", Name: "app", Tags: map[string]string{"team": "a"}}
+	untagged := msiTemplateData{ResourceGroup: "rg-team-a", Name: "app"}
+
+	tests := []struct {
+		data      msiTemplateData
+		namespace string
+		labels    map[string]string
+		allowed   bool
+		rule      string
+	}{
+		{platform, "kube-dns", nil, true, "platform"},
+		{platform, "platform-ingress", nil, true, "platform"},
+		{platform, "team-a", nil, false, "platform"},
+		{legacy, "platform", nil, false, "platform-exception"},
+		{team, "team-a", map[string]string{"team": "a"}, true, "teams"},
+		{team, "team-b", map[string]string{"team": "b"}, false, "teams"},
+		{team, "team-a", nil, false, "teams"},
+		{untagged, "team-a", map[string]string{"team": ""}, false, MsiPolicyRuleDefault},
+	}
+
+	for _, test := range tests {
+		decision := policy.Evaluate(test.data, test.namespace, test.labels)
+		if decision.Allowed != test.allowed || decision.Rule != test.rule {
+			t.Errorf("%s/%s in namespace %s: expected allowed=%v (rule %s), got %+v", test.data.ResourceGroup, test.data.Name, test.namespace, test.allowed, test.rule, decision)
+		}
+		if !decision.Allowed && decision.Reason == "" {
+			t.Errorf("%s/%s in namespace %s: expected denial reason", test.data.ResourceGroup, test.data.Name, test.namespace)
+		}
+	}
+}
+
+func TestMsiPolicyDefaultAction(t *testing.T) {
+	policy, err := ParseMsiPolicy([]byte(`rules: []`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := policy.Evaluate(msiTemplateData{}, "default", nil); decision.Allowed {
+		t.Fatalf("expected deny as default action, got %+v", decision)
+	}
+
+	policy, err = ParseMsiPolicy([]byte(`defaultAction: allow`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision := policy.Evaluate(msiTemplateData{}, "default", nil); !decision.Allowed {
+		t.Fatalf("expected allow as default action, got %+v", decision)
+	}
+}
+
+func TestMsiPolicyInvalid(t *testing.T) {
+	invalid := []string{
+		`defaultAction: maybe`,
+		`unknownField: true`,
+		`rules: [{match: {names: ["foo-["]}}]`,
+		`rules: [{namespaces: ["/team-(/"]}]`,
+		`rules: [{namespaceLabels: {team: "{{ .Tags "}}]`,
+	}
+
+	for _, content := range invalid {
+		if _, err := ParseMsiPolicy([]byte(content)); err == nil {
+			t.Errorf("expected error for invalid policy %q", content)
+		}
+	}
+}
+
+func TestApiIdentitiesPolicy(t *testing.T) {
+	m := newTestOperator()
+
+	excluded := testSyncMsiResourceInfo("excluded", "team-a")
+	excluded.KubernetesClusters = []string{"prod-*"}
+	m.serviceDiscovery.msi.Add(testSyncMsiResourceInfo("foo", "team-a", "team-b"))
+	m.serviceDiscovery.msi.Add(excluded)
+	m.serviceDiscovery.msi.Commit()
+
+	// decisions of last sync, the policy isn't evaluated per request
+	resourceId := to.String(testSyncMsiResourceInfo("foo").AzureResourceId)
+	m.serviceDiscovery.msi.SetDenied(resourceId, "team-a", "outdated")
+	m.serviceDiscovery.msi.SetStatus(resourceId, "team-a", MsiSyncStatusSynced)
+	m.serviceDiscovery.msi.SetDenied(resourceId, "team-b", "rule \"team-a\": namespace not allowed")
+
+	identities := m.apiIdentities("", false)
+	if len(identities) != 2 {
+		t.Fatalf("expected 2 identities, got %d", len(identities))
+	}
+	for _, identity := range identities {
+		switch identity.Name {
+		case "foo":
+			if !reflect.DeepEqual(identity.Namespaces, []string{"team-a"}) || len(identity.Denials) != 1 || identity.Denials["team-b"] == "" {
+				t.Errorf("expected only team-a allowed and team-b denied, got %+v", identity)
+			}
+		case "excluded":
+			if !identity.ClusterExcluded || len(identity.Namespaces) != 0 {
+				t.Errorf("expected identity excluded from cluster without namespaces, got %+v", identity)
+			}
+		}
+	}
+
+	if identities := m.apiIdentities("team-b", false); len(identities) != 0 {
+		t.Errorf("expected no identities allowed in team-b, got %+v", identities)
+	}
+	if identities := m.apiIdentities("team-a", false); len(identities) != 1 || identities[0].Name != "foo" {
+		t.Errorf("expected only foo in team-a, got %+v", identities)
+	}
+
+	// no requests to the Kubernetes API server
+	if actions := m.kubernetes.client.(*dynamicfake.FakeDynamicClient).Actions(); len(actions) != 0 {
+		t.Errorf("expected no Kubernetes requests, got %v", actions)
+	}
+}
@@ -0,0 +1,173 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/authorization/mgmt/authorization"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
+	"github.com/Azure/go-autorest/autorest/to"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var (
+	// subscription scope (/subscriptions/xxx), management group scope or root scope (/)
+	roleAssignmentSubscriptionScope = regexp.MustCompile(`(?i)^(/|/subscriptions/[^/]+|/providers/Microsoft\.Management/managementGroups/[^/]+)/?$`)
+)
+
+type (
+	// MsiRoleAssignment is a summarized Azure RBAC role assignment of an Azure MSI
+	MsiRoleAssignment struct {
+		RoleName string `json:"role"`
+		Scope    string `json:"scope"`
+	}
+)
+
+// updateAzureRoleAssignments fetches the role assignments of all subscriptions, grouped by principal id
+// if the role assignments can't be fetched, the role assignments of the last successful discovery are kept
+func (m *MsiOperator) updateAzureRoleAssignments(ctx context.Context) {
+	if !m.Conf.Azure.RoleAssignments.Enabled {
+		return
+	}
+
+	ctx, span := m.startSpan(ctx, "discover role assignments")
+
+	roleAssignments := map[string][]MsiRoleAssignment{}
+	for _, v := range m.azure.subscriptionList {
+		subscription := v
+		if err := m.fetchAzureRoleAssignments(ctx, &subscription, roleAssignments); err != nil {
+			err = fmt.Errorf("failed to fetch role assignments of Azure Subscription \"%s\": %w", to.String(subscription.SubscriptionID), err)
+			m.Logger.Warnf("%v, using role assignments of previous discovery", err)
+			m.history.addError("%v", err)
+			endSpan(span, err)
+			return
+		}
+	}
+
+	for principalId, list := range roleAssignments {
+		roleAssignments[principalId] = normalizeRoleAssignments(list)
+	}
+	m.azure.roleAssignments = roleAssignments
+
+	span.End()
+}
+
+// fetchAzureRoleAssignments adds the role assignments (within and inherited by the subscription) to the principal map
+func (m *MsiOperator) fetchAzureRoleAssignments(ctx context.Context, subscription *subscriptions.Subscription, roleAssignments map[string][]MsiRoleAssignment) error {
+	subscriptionId := to.String(subscription.SubscriptionID)
+
+	// role names
+	roleDefinitionClient := authorization.NewRoleDefinitionsClientWithBaseURI(m.azure.environment.ResourceManagerEndpoint, subscriptionId)
+	m.decorateAzureClient(&roleDefinitionClient.Client)
+
+	roleNames := map[string]string{}
+	roleDefinitionList, err := roleDefinitionClient.ListComplete(ctx, "/subscriptions/"+subscriptionId, "")
+	if err != nil {
+		return err
+	}
+	for roleDefinitionList.NotDone() {
+		roleDefinition := roleDefinitionList.Value()
+		if roleDefinition.RoleDefinitionProperties != nil {
+			roleNames[strings.ToLower(to.String(roleDefinition.Name))] = to.String(roleDefinition.RoleName)
+		}
+
+		if roleDefinitionList.NextWithContext(ctx) != nil {
+			break
+		}
+	}
+
+	// role assignments
+	roleAssignmentClient := authorization.NewRoleAssignmentsClientWithBaseURI(m.azure.environment.ResourceManagerEndpoint, subscriptionId)
+	m.decorateAzureClient(&roleAssignmentClient.Client)
+
+	roleAssignmentList, err := roleAssignmentClient.ListComplete(ctx, "")
+	if err != nil {
+		return err
+	}
+	for roleAssignmentList.NotDone() {
+		roleAssignment := roleAssignmentList.Value()
+		if roleAssignment.Properties != nil && roleAssignment.Properties.PrincipalID != nil {
+			// role definition id: /subscriptions/xxx/providers/Microsoft.Authorization/roleDefinitions/{guid}
+			roleDefinitionName := strings.ToLower(path.Base(to.String(roleAssignment.Properties.RoleDefinitionID)))
+			roleName, exists := roleNames[roleDefinitionName]
+			if !exists || roleName == "" {
+				roleName = roleDefinitionName
+			}
+
+			principalId := strings.ToLower(to.String(roleAssignment.Properties.PrincipalID))
+			roleAssignments[principalId] = append(roleAssignments[principalId], MsiRoleAssignment{
+				RoleName: roleName,
+				Scope:    to.String(roleAssignment.Properties.Scope),
+			})
+		}
+
+		if roleAssignmentList.NextWithContext(ctx) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// normalizeRoleAssignments sorts the role assignments and removes duplicates (inherited assignments are listed per subscription)
+func normalizeRoleAssignments(list []MsiRoleAssignment) []MsiRoleAssignment {
+	ret := []MsiRoleAssignment{}
+	seen := map[string]bool{}
+	for _, roleAssignment := range list {
+		key := strings.ToLower(roleAssignment.RoleName + "|" + strings.TrimSuffix(roleAssignment.Scope, "/"))
+		if !seen[key] {
+			seen[key] = true
+			ret = append(ret, roleAssignment)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Scope != ret[j].Scope {
+			return ret[i].Scope < ret[j].Scope
+		}
+		return ret[i].RoleName < ret[j].RoleName
+	})
+	return ret
+}
+
+// highPrivilegeRoleAssignments returns the role assignments with high privilege roles at subscription (or higher) scope
+func highPrivilegeRoleAssignments(list []MsiRoleAssignment, roles []string) (ret []MsiRoleAssignment) {
+	for _, roleAssignment := range list {
+		if !roleAssignmentSubscriptionScope.MatchString(roleAssignment.Scope) {
+			continue
+		}
+
+		for _, role := range roles {
+			if strings.EqualFold(roleAssignment.RoleName, role) {
+				ret = append(ret, roleAssignment)
+				break
+			}
+		}
+	}
+	return
+}
+
+// applyMsiRoleAssignmentsToK8sObject sets the role assignments annotation (JSON)
+// the annotation is removed if role assignments are disabled or not fetched yet (unknown, not empty)
+func (m *MsiOperator) applyMsiRoleAssignmentsToK8sObject(msiInfo MsiResourceInfo, k8sResource *unstructured.Unstructured) error {
+	annotationName := m.labelName("roleassignments")
+	if !m.Conf.Azure.RoleAssignments.Enabled || msiInfo.RoleAssignments == nil {
+		unstructured.RemoveNestedField(k8sResource.Object, "metadata", "annotations", annotationName)
+		return nil
+	}
+
+	val, err := json.Marshal(msiInfo.RoleAssignments)
+	if err != nil {
+		return err
+	}
+
+	if err := unstructured.SetNestedField(k8sResource.Object, string(val), "metadata", "annotations", annotationName); err != nil {
+		return fmt.Errorf("failed to set metadata.annotations[%v] value: %w", annotationName, err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNormalizeRoleAssignments(t *testing.T) {
+	list := normalizeRoleAssignments([]MsiRoleAssignment{
+		{RoleName: "Reader", Scope: "/subscriptions/bbb"},
+		{RoleName: "Contributor", Scope: "/subscriptions/aaa/resourceGroups/foo"},
+		{RoleName: "Reader", Scope: "/subscriptions/bbb"},
+		{RoleName: "Owner", Scope: "/subscriptions/aaa/resourceGroups/foo"},
+	})
+
+	expected := []MsiRoleAssignment{
+		{RoleName: "Contributor", Scope: "/subscriptions/aaa/resourceGroups/foo"},
+		{RoleName: "Owner", Scope: "/subscriptions/aaa/resourceGroups/foo"},
+		{RoleName: "Reader", Scope: "/subscriptions/bbb"},
+	}
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("unexpected role assignments %+v", list)
+	}
+}
+
+func TestHighPrivilegeRoleAssignments(t *testing.T) {
+	roles := []string{"Owner", "Contributor"}
+	list := []MsiRoleAssignment{
+		{RoleName: "Owner", Scope: "/subscriptions/aaa"},
+		{RoleName: "contributor", Scope: "/providers/Microsoft.Management/managementGroups/root/"},
+		{RoleName: "Contributor", Scope: "/"},
+		{RoleName: "Contributor", Scope: "/subscriptions/aaa/resourceGroups/foo"},
+		{RoleName: "Reader", Scope: "/subscriptions/aaa"},
+	}
+
+	ret := highPrivilegeRoleAssignments(list, roles)
+	if len(ret) != 3 || ret[0] != list[0] || ret[1] != list[1] || ret[2] != list[2] {
+		t.Fatalf("unexpected high privilege role assignments %+v", ret)
+	}
+
+	if ret := highPrivilegeRoleAssignments(list, nil); len(ret) != 0 {
+		t.Fatalf("expected no high privilege role assignments without roles, got %+v", ret)
+	}
+}
+
+func TestApplyMsiRoleAssignmentsToK8sObject(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Azure.RoleAssignments.Enabled = true
+	annotationName := m.labelName("roleassignments")
+
+	apply := func(roleAssignments []MsiRoleAssignment) (string, bool) {
+		k8sResource := &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{annotationName: `[{"role":"Owner","scope":"/"}]`},
+			},
+		}}
+		if err := m.applyMsiRoleAssignmentsToK8sObject(MsiResourceInfo{RoleAssignments: roleAssignments}, k8sResource); err != nil {
+			t.Fatal(err)
+		}
+		val, exists, _ := unstructured.NestedString(k8sResource.Object, "metadata", "annotations", annotationName)
+		return val, exists
+	}
+
+	// not fetched yet, unknown
+	if val, exists := apply(nil); exists {
+		t.Errorf("expected no annotation if role assignments are unknown, got %q", val)
+	}
+	if val, _ := apply([]MsiRoleAssignment{}); val != "[]" {
+		t.Errorf("expected empty role assignments, got %q", val)
+	}
+	if val, _ := apply([]MsiRoleAssignment{{RoleName: "Reader", Scope: "/subscriptions/aaa"}}); val != `[{"role":"Reader","scope":"/subscriptions/aaa"}]` {
+		t.Errorf("unexpected role assignments annotation %q", val)
+	}
+}
+
+func TestApiIdentitiesRoleAssignments(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Server.ApiToken = "secret"
+	m.Conf.Azure.RoleAssignments.HighPrivilege = []string{"Owner"}
+	msiInfo := testSyncMsiResourceInfo("foo", "team-a")
+	msiInfo.RoleAssignments = []MsiRoleAssignment{{RoleName: "Owner", Scope: "/subscriptions/aaa"}}
+	m.serviceDiscovery.msi.Update(msiInfo)
+
+	request := func(token string) ApiIdentity {
+		r := httptest.NewRequest(http.MethodGet, ApiPathIdentities, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		m.HandleApiIdentities(w, r)
+
+		response := []ApiIdentity{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response) != 1 {
+			t.Fatalf("expected 1 identity, got %d", len(response))
+		}
+		return response[0]
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if identity := request(token); identity.RoleAssignments != nil || identity.HighPrivilege {
+			t.Errorf("expected no role assignments without valid token, got %+v", identity)
+		}
+	}
+	if identity := request("secret"); len(identity.RoleAssignments) != 1 || !identity.HighPrivilege {
+		t.Errorf("expected role assignments with valid token, got %+v", identity)
+	}
+}
@@ -0,0 +1,152 @@
+package operator
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	// schedule names (used as metric label)
+	SyncScheduleDiscovery = "discovery"
+	SyncScheduleReconcile = "reconcile"
+	SyncScheduleStandby   = "standby"
+)
+
+type (
+	// syncScheduler calculates the delay until the next run of a schedule
+	// failed runs are retried using exponential backoff, all delays are jittered
+	syncScheduler struct {
+		name           string
+		interval       time.Duration
+		jitter         float64
+		backoffInitial time.Duration
+		backoffMax     time.Duration
+
+		// number of consecutive failed runs
+		failures int
+	}
+)
+
+func (m *MsiOperator) newSyncScheduler(name string, interval time.Duration) *syncScheduler {
+	return &syncScheduler{
+		name:           name,
+		interval:       interval,
+		jitter:         m.Conf.Sync.Jitter,
+		backoffInitial: m.Conf.Sync.BackoffInitial,
+		backoffMax:     m.Conf.Sync.BackoffMax,
+	}
+}
+
+// next returns the delay until the next run based on the result of the last run
+func (s *syncScheduler) next(err error) time.Duration {
+	delay := s.interval
+	if err != nil {
+		s.failures++
+		delay = s.backoff()
+	} else {
+		s.failures = 0
+	}
+
+	return s.addJitter(delay)
+}
+
+// backoff returns the retry delay for the current number of failures (doubled on each failure)
+// capped at backoffMax and the regular interval
+func (s *syncScheduler) backoff() time.Duration {
+	limit := s.backoffMax
+	if s.interval < limit {
+		limit = s.interval
+	}
+
+	delay := s.backoffInitial
+	for i := 1; i < s.failures && delay < limit; i++ {
+		delay *= 2
+	}
+
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// addJitter adds a random delay up to jitter*delay
+func (s *syncScheduler) addJitter(delay time.Duration) time.Duration {
+	if s.jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Float64()*s.jitter*float64(delay)) // #nosec G404 -- jitter doesn't need crypto random
+}
+
+// startScheduledSync runs the sync immediately and afterwards according to the schedule until ctx is cancelled
+// retries of failed runs are passed as force to the sync function
+func (m *MsiOperator) startScheduledSync(ctx context.Context, schedule *syncScheduler, fn func(ctx context.Context, force bool) error) {
+	err := m.run(ctx, schedule.name, func(ctx context.Context) error {
+		return fn(ctx, false)
+	})
+
+	go func() {
+		for {
+			delay := schedule.next(err)
+			m.prometheus.nextRun.WithLabelValues(schedule.name).Set(float64(time.Now().Add(delay).Unix()))
+
+			if err != nil {
+				m.Logger.Warnf("%s failed (%d times in a row), retrying in %s", schedule.name, schedule.failures, delay.Round(time.Second).String())
+			} else {
+				m.Logger.Debugf("next %s in %s", schedule.name, delay.Round(time.Second).String())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			retry := schedule.failures > 0
+			err = m.run(ctx, schedule.name, func(ctx context.Context) error {
+				return fn(ctx, retry)
+			})
+		}
+	}()
+}
+
+// run waits for running syncs and runs fn (recorded in sync run history, health status and traced), skipped if the operator is shutting down or not leader
+// fn gets the leader context for API calls (cancelled after shutdown timeout or when leadership is lost) containing the span of the run
+func (m *MsiOperator) run(ctx context.Context, trigger string, fn func(ctx context.Context) error) (err error) {
+	if err := m.runLock.Acquire(ctx, 1); err != nil {
+		// shutting down or lost leadership
+		return nil
+	}
+	defer m.runLock.Release(1)
+
+	if m.stopCtx.Err() != nil || !m.health.isLeader() {
+		return nil
+	}
+
+	runCtx, span := m.startSpan(contextWithSyncTrigger(m.leaderContext(ctx), trigger), "run "+trigger, TraceAttrSyncTrigger.String(trigger))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	m.history.begin(trigger)
+	err = fn(runCtx)
+	m.health.setRunResult(err)
+	snapshotVersion := m.serviceDiscovery.msi.Version()
+	span.SetAttributes(TraceAttrSnapshotVersion.Int64(int64(snapshotVersion)))
+	if lastRun := m.history.end(err, snapshotVersion); lastRun != nil {
+		m.Logger.Infof(
+			"finished %s run #%d after %.1fs (created: %d, updated: %d, unchanged: %d, errors: %d)",
+			trigger,
+			lastRun.Id,
+			lastRun.Duration,
+			lastRun.Created,
+			lastRun.Updated,
+			lastRun.Unchanged,
+			lastRun.Errors,
+		)
+		m.writeHistoryConfigMap(runCtx, lastRun)
+	}
+	m.updateInventoryMetrics(runCtx)
+
+	return err
+}
@@ -0,0 +1,138 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncSchedulerBackoff(t *testing.T) {
+	schedule := &syncScheduler{
+		interval:       time.Hour,
+		backoffInitial: 30 * time.Second,
+		backoffMax:     5 * time.Minute,
+	}
+
+	runErr := errors.New("failed")
+	expected := []time.Duration{
+		30 * time.Second,
+		1 * time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		5 * time.Minute,
+		5 * time.Minute,
+	}
+
+	for i, delay := range expected {
+		if result := schedule.next(runErr); result != delay {
+			t.Errorf("failure %d: expected %v, got %v", i+1, delay, result)
+		}
+	}
+
+	// successful run resets backoff
+	if result := schedule.next(nil); result != time.Hour {
+		t.Errorf("success: expected %v, got %v", time.Hour, result)
+	}
+	if result := schedule.next(runErr); result != 30*time.Second {
+		t.Errorf("failure after success: expected %v, got %v", 30*time.Second, result)
+	}
+
+	// backoff is capped at interval
+	schedule.interval = 90 * time.Second
+	for i := 0; i < 5; i++ {
+		schedule.next(runErr)
+	}
+	if result := schedule.next(runErr); result != 90*time.Second {
+		t.Errorf("capped: expected %v, got %v", 90*time.Second, result)
+	}
+}
+
+func TestSyncSchedulerJitter(t *testing.T) {
+	schedule := &syncScheduler{
+		interval: time.Hour,
+		jitter:   0.1,
+	}
+
+	for i := 0; i < 100; i++ {
+		result := schedule.next(nil)
+		if result < time.Hour || result > time.Hour+6*time.Minute {
+			t.Fatalf("expected delay between 1h and 1h6m, got %v", result)
+		}
+	}
+}
+
+func TestRunUsesLeaderContext(t *testing.T) {
+	m := newTestOperator()
+	m.health.setLeader(true)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	loopCtx, cancelLoop := context.WithCancel(context.WithValue(leaderCtx, leaderContextKey{}, leaderCtx))
+	defer cancelLoop()
+
+	err := m.run(loopCtx, SyncRunTriggerManual, func(ctx context.Context) error {
+		if syncTriggerFromContext(ctx) != SyncRunTriggerManual {
+			t.Errorf("expected sync trigger in context")
+		}
+
+		// lost leadership cancels API calls of the run
+		cancelLeader()
+		if ctx.Err() == nil {
+			t.Errorf("expected cancelled run context after losing leadership")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// runs are skipped if not leader
+	m.health.setLeader(false)
+	err = m.run(context.Background(), SyncRunTriggerManual, func(ctx context.Context) error {
+		t.Errorf("expected skipped run if not leader")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunRecordsHealthStatus(t *testing.T) {
+	m := newTestOperator()
+	m.health.setLeader(true)
+
+	// failed reconcile (without discovery) is recorded
+	runErr := errors.New("failed to upsert namespace")
+	if err := m.run(context.Background(), SyncScheduleReconcile, func(ctx context.Context) error { return runErr }); err != runErr {
+		t.Fatalf("expected run error, got %v", err)
+	}
+	if m.health.lastRun.IsZero() || m.health.lastError != runErr.Error() {
+		t.Errorf("expected failed run in health status, got %+v", m.health)
+	}
+
+	if err := m.run(context.Background(), SyncScheduleReconcile, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if m.health.lastSuccess.IsZero() || m.health.lastError != "" {
+		t.Errorf("expected successful run in health status, got %+v", m.health)
+	}
+}
+
+func TestWatchUpsertRun(t *testing.T) {
+	m := newTestOperator()
+	m.health.setLeader(true)
+	m.Conf.Sync.LockTime = time.Minute
+
+	m.watchUpsert(context.Background(), SyncRunTriggerWatchNamespace, "team-a", true, false)
+	runs := m.history.list()
+	if len(runs) != 1 || runs[0].Trigger != SyncRunTriggerWatchNamespace {
+		t.Fatalf("expected watch run in history, got %+v", runs)
+	}
+
+	// events within the sync lock time (eg. caused by the last upsert) don't start a run
+	m.history = newSyncRunHistory(1)
+	m.watchUpsert(context.Background(), SyncRunTriggerWatchBinding, "team-a", false, true)
+	if runs := m.history.list(); len(runs) != 0 {
+		t.Errorf("expected no run within sync lock time, got %+v", runs)
+	}
+}
@@ -1,26 +1,70 @@
 package operator
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/msi/mgmt/msi"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	MsiSyncStatusSynced           = "Synced"
+	MsiSyncStatusFailed           = "Failed"
+	MsiSyncStatusNamespaceMissing = "NamespaceMissing"
+	MsiSyncStatusDenied           = "Denied"
+
+	// resource type of user-assigned identities, other types are system-assigned identities of Azure resources
+	AzureMsiResourceTypeUserAssigned = "Microsoft.ManagedIdentity/userAssignedIdentities"
 )
 
 type (
 	MsiResourceList struct {
 		list       []MsiResourceInfo
 		uncommited []MsiResourceInfo
+		status     map[string]map[string]string
+		denials    map[string]map[string]string
+		version    uint64
 		lock       sync.Mutex
 	}
 
+	// MsiResourceListDiff contains the changes of a commited snapshot compared to the previous snapshot
+	MsiResourceListDiff struct {
+		Version uint64
+		Added   []MsiResourceInfo
+		Removed []MsiResourceInfo
+		Changed []MsiResourceInfo
+	}
+
 	MsiResourceInfo struct {
-		Resource               *msi.Identity
-		AzureResourceId        *string
-		AzureResourceName      *string
-		AzureResourceGroup     *string
-		AzureSubscriptionId    *string
-		KubernetesResourceName *string
-		KubernetesNamespace    []string
+		Resource                  *msi.Identity
+		AzureResourceId           *string
+		AzureResourceName         *string
+		AzureResourceGroup        *string
+		AzureSubscriptionId       *string
+		KubernetesResourceName    *string
+		KubernetesNamespace       []string
+		KubernetesBindingSelector *string
+
+		// Azure RBAC role assignments of the principal (nil if disabled)
+		RoleAssignments []MsiRoleAssignment
+
+		// target clusters (cluster names or globs, all clusters if empty)
+		KubernetesClusters []string
+
+		// namespaces ignored by namespace filter
+		KubernetesNamespaceIgnored []string
+
+		// system-assigned identity of an Azure resource
+		SystemAssigned bool
+
+		// not synced to Kubernetes (system-assigned identities without --azure.systemassigned.sync)
+		SyncDisabled bool
 	}
 )
 
@@ -28,6 +72,8 @@ func NewMsiResourceList() *MsiResourceList {
 	return &MsiResourceList{
 		list:       []MsiResourceInfo{},
 		uncommited: []MsiResourceInfo{},
+		status:     map[string]map[string]string{},
+		denials:    map[string]map[string]string{},
 	}
 }
 
@@ -41,11 +87,105 @@ func (m *MsiResourceList) Clean() {
 	m.uncommited = []MsiResourceInfo{}
 }
 
-func (m *MsiResourceList) Commit() {
+// Commit replaces the list with the uncommited resources as new snapshot and returns the changes
+func (m *MsiResourceList) Commit() *MsiResourceListDiff {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.list = m.uncommited
+
+	diff := m.replace(m.uncommited)
 	m.uncommited = []MsiResourceInfo{}
+	return diff
+}
+
+// Update adds or replaces a single resource as new snapshot and returns the changes
+func (m *MsiResourceList) Update(val MsiResourceInfo) *MsiResourceListDiff {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	resourceId := to.String(val.AzureResourceId)
+	list := []MsiResourceInfo{}
+	for _, row := range m.list {
+		if to.String(row.AzureResourceId) != resourceId {
+			list = append(list, row)
+		}
+	}
+	list = append(list, val)
+
+	return m.replace(list)
+}
+
+// Remove removes a single resource as new snapshot and returns the changes
+func (m *MsiResourceList) Remove(resourceId string) *MsiResourceListDiff {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := []MsiResourceInfo{}
+	for _, row := range m.list {
+		if to.String(row.AzureResourceId) != resourceId {
+			list = append(list, row)
+		}
+	}
+
+	return m.replace(list)
+}
+
+// replace replaces the list as new snapshot and returns the changes, lock must be held by caller
+func (m *MsiResourceList) replace(list []MsiResourceInfo) *MsiResourceListDiff {
+	previous := map[string]MsiResourceInfo{}
+	for _, row := range m.list {
+		previous[to.String(row.AzureResourceId)] = row
+	}
+
+	m.version++
+	m.list = list
+
+	diff := &MsiResourceListDiff{Version: m.version}
+	status := map[string]map[string]string{}
+	denials := map[string]map[string]string{}
+	for _, row := range m.list {
+		resourceId := to.String(row.AzureResourceId)
+
+		if prev, exists := previous[resourceId]; !exists {
+			diff.Added = append(diff.Added, row)
+		} else if fingerprint := row.fingerprint(); fingerprint == "" || fingerprint != prev.fingerprint() {
+			diff.Changed = append(diff.Changed, row)
+		}
+		delete(previous, resourceId)
+
+		// cleanup status of removed resources and namespaces
+		if val, exists := m.status[resourceId]; exists {
+			status[resourceId] = map[string]string{}
+			for namespace, namespaceStatus := range val {
+				if contains(row.KubernetesNamespace, namespace) {
+					status[resourceId][namespace] = namespaceStatus
+				}
+			}
+		}
+
+		if val, exists := m.denials[resourceId]; exists {
+			denials[resourceId] = map[string]string{}
+			for namespace, reason := range val {
+				if contains(row.KubernetesNamespace, namespace) {
+					denials[resourceId][namespace] = reason
+				}
+			}
+		}
+	}
+	m.status = status
+	m.denials = denials
+
+	for _, row := range previous {
+		diff.Removed = append(diff.Removed, row)
+	}
+
+	return diff
+}
+
+// Version returns the version of the commited snapshot
+func (m *MsiResourceList) Version() uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.version
 }
 
 func (m *MsiResourceList) GetList() []MsiResourceInfo {
@@ -53,3 +193,132 @@ func (m *MsiResourceList) GetList() []MsiResourceInfo {
 	defer m.lock.Unlock()
 	return m.list
 }
+
+// Find returns all (commited) resources matching the filter
+func (m *MsiResourceList) Find(filter func(MsiResourceInfo) bool) (ret []MsiResourceInfo) {
+	for _, row := range m.GetList() {
+		if filter(row) {
+			ret = append(ret, row)
+		}
+	}
+	return
+}
+
+// SetStatus sets the sync status of an Azure MSI for a Kubernetes namespace
+func (m *MsiResourceList) SetStatus(resourceId, namespace, status string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.status[resourceId]; !exists {
+		m.status[resourceId] = map[string]string{}
+	}
+	m.status[resourceId][namespace] = status
+
+	if status != MsiSyncStatusDenied && m.denials[resourceId] != nil {
+		delete(m.denials[resourceId], namespace)
+	}
+}
+
+// SetDenied sets the sync status of an Azure MSI for a Kubernetes namespace to denied (by policy) with the reason
+func (m *MsiResourceList) SetDenied(resourceId, namespace, reason string) {
+	m.SetStatus(resourceId, namespace, MsiSyncStatusDenied)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.denials[resourceId]; !exists {
+		m.denials[resourceId] = map[string]string{}
+	}
+	m.denials[resourceId][namespace] = reason
+}
+
+// GetDenials returns the policy denial reasons (per Kubernetes namespace) of an Azure MSI
+func (m *MsiResourceList) GetDenials(resourceId string) map[string]string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := map[string]string{}
+	for namespace, reason := range m.denials[resourceId] {
+		ret[namespace] = reason
+	}
+	return ret
+}
+
+// GetStatus returns the sync status (per Kubernetes namespace) of an Azure MSI
+func (m *MsiResourceList) GetStatus(resourceId string) map[string]string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ret := map[string]string{}
+	for namespace, status := range m.status[resourceId] {
+		ret[namespace] = status
+	}
+	return ret
+}
+
+// TargetsCluster returns true if the Azure MSI should be synced to the cluster (cluster filter)
+func (m MsiResourceInfo) TargetsCluster(name string) bool {
+	if len(m.KubernetesClusters) == 0 {
+		return true
+	}
+
+	name = strings.ToLower(name)
+	for _, pattern := range m.KubernetesClusters {
+		// invalid globs don't match
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprint returns a hash of all values relevant for Kubernetes resources
+func (m MsiResourceInfo) fingerprint() string {
+	val := map[string]interface{}{
+		"resourceName":    m.KubernetesResourceName,
+		"namespaces":      m.KubernetesNamespace,
+		"bindingSelector": m.KubernetesBindingSelector,
+		"clusters":        m.KubernetesClusters,
+		"roleAssignments": m.RoleAssignments,
+	}
+
+	if m.Resource != nil {
+		val["tags"] = m.Resource.Tags
+		if m.Resource.UserAssignedIdentityProperties != nil {
+			val["clientId"] = m.Resource.ClientID
+			val["principalId"] = m.Resource.PrincipalID
+			val["tenantId"] = m.Resource.TenantID
+		}
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		// unable to compare, handle as changed
+		return ""
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// HasChanges returns true if resources were added, removed or changed
+func (d *MsiResourceListDiff) HasChanges() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Changed) > 0
+}
+
+// Namespaces returns the Kubernetes namespaces of added and changed resources (sorted)
+func (d *MsiResourceListDiff) Namespaces() []string {
+	ret := []string{}
+	for _, list := range [][]MsiResourceInfo{d.Added, d.Changed} {
+		for _, row := range list {
+			if row.SyncDisabled {
+				// not synced
+				continue
+			}
+
+			for _, namespace := range row.KubernetesNamespace {
+				if !contains(ret, namespace) {
+					ret = append(ret, namespace)
+				}
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
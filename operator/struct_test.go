@@ -0,0 +1,90 @@
+package operator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/msi/mgmt/msi"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+func testMsiResourceInfo(name string, tags map[string]string, namespaces ...string) MsiResourceInfo {
+	return MsiResourceInfo{
+		Resource: &msi.Identity{
+			Tags: *to.StringMapPtr(tags),
+		},
+		AzureResourceId:        to.StringPtr("/subscriptions/xxx/resourcegroups/rg/providers/microsoft.managedidentity/userassignedidentities/" + name),
+		AzureResourceName:      to.StringPtr(name),
+		KubernetesResourceName: to.StringPtr(name),
+		KubernetesNamespace:    namespaces,
+	}
+}
+
+func TestMsiResourceListCommit(t *testing.T) {
+	list := NewMsiResourceList()
+
+	list.Add(testMsiResourceInfo("foo", map[string]string{"k8snamespace": "team-a"}, "team-a"))
+	list.Add(testMsiResourceInfo("bar", map[string]string{"k8snamespace": "team-b"}, "team-b"))
+	list.Add(testMsiResourceInfo("baz", map[string]string{"k8snamespace": "team-c"}, "team-c"))
+	diff := list.Commit()
+	if diff.Version != 1 || len(diff.Added) != 3 || !reflect.DeepEqual(diff.Namespaces(), []string{"team-a", "team-b", "team-c"}) {
+		t.Fatalf("first snapshot: unexpected diff %+v", diff)
+	}
+
+	// unchanged snapshot
+	list.Clean()
+	list.Add(testMsiResourceInfo("foo", map[string]string{"k8snamespace": "team-a"}, "team-a"))
+	list.Add(testMsiResourceInfo("bar", map[string]string{"k8snamespace": "team-b"}, "team-b"))
+	list.Add(testMsiResourceInfo("baz", map[string]string{"k8snamespace": "team-c"}, "team-c"))
+	diff = list.Commit()
+	if diff.Version != 2 || diff.HasChanges() {
+		t.Fatalf("unchanged snapshot: unexpected diff %+v", diff)
+	}
+
+	// changed tag, removed resource
+	list.Clean()
+	list.Add(testMsiResourceInfo("foo", map[string]string{"k8snamespace": "team-a", "k8sselector": "foo"}, "team-a"))
+	list.Add(testMsiResourceInfo("bar", map[string]string{"k8snamespace": "team-b"}, "team-b"))
+	diff = list.Commit()
+	if len(diff.Added) != 0 || len(diff.Changed) != 1 || len(diff.Removed) != 1 {
+		t.Fatalf("changed snapshot: unexpected diff %+v", diff)
+	}
+	if namespaces := diff.Namespaces(); !reflect.DeepEqual(namespaces, []string{"team-a"}) {
+		t.Errorf("changed snapshot: expected namespaces [team-a], got %v", namespaces)
+	}
+	if list.Version() != 3 {
+		t.Errorf("expected version 3, got %v", list.Version())
+	}
+}
+
+func TestMsiResourceInfoTargetsCluster(t *testing.T) {
+	msiInfo := testMsiResourceInfo("foo", nil, "team-a")
+	if !msiInfo.TargetsCluster("prod-weu-1") {
+		t.Errorf("expected Azure MSI without clusters to target all clusters")
+	}
+
+	msiInfo.KubernetesClusters = splitTemplateList("prod-weu-1, Prod-NEU-1,")
+	if !reflect.DeepEqual(msiInfo.KubernetesClusters, []string{"prod-weu-1", "prod-neu-1"}) {
+		t.Fatalf("unexpected clusters %v", msiInfo.KubernetesClusters)
+	}
+	if !msiInfo.TargetsCluster("PROD-NEU-1") || msiInfo.TargetsCluster("dev-weu-1") {
+		t.Errorf("unexpected cluster selection for %v", msiInfo.KubernetesClusters)
+	}
+
+	// globs
+	msiInfo.KubernetesClusters = splitTemplateList("prod-*,dev-weu-?")
+	for cluster, expected := range map[string]bool{
+		"prod-weu-1": true,
+		"dev-weu-1":  true,
+		"dev-weu-10": false,
+		"staging":    false,
+	} {
+		if msiInfo.TargetsCluster(cluster) != expected {
+			t.Errorf("%s: expected %v for %v", cluster, expected, msiInfo.KubernetesClusters)
+		}
+	}
+
+	if reason := msiSkipReason(msiInfo, "staging"); reason != MsiSkipReasonClusterExcluded {
+		t.Errorf("expected skip reason %s, got %s", MsiSkipReasonClusterExcluded, reason)
+	}
+}
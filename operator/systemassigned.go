@@ -0,0 +1,163 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/msi/mgmt/msi"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/resources"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/subscriptions"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/gofrs/uuid"
+
+	"github.com/webdevops/go-common/prometheus/azuretracing"
+)
+
+// initAzureSystemAssigned sets up the Microsoft Graph client for the client id lookup of system-assigned identities
+func (m *MsiOperator) initAzureSystemAssigned() {
+	if len(m.Conf.Azure.SystemAssigned) == 0 {
+		return
+	}
+
+	for _, resourceType := range m.Conf.Azure.SystemAssigned {
+		// used in $filter of resource list
+		if strings.Count(resourceType, "/") < 1 || strings.ContainsAny(resourceType, "' ") {
+			m.Logger.Panicf("invalid resource type \"%s\" for system-assigned identities (eg. Microsoft.Compute/virtualMachineScaleSets)", resourceType)
+		}
+	}
+
+	authorizer, err := auth.NewAuthorizerFromEnvironmentWithResource(m.azure.environment.MicrosoftGraphEndpoint)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+
+	m.azure.graphClient = autorest.NewClientWithUserAgent(m.UserAgent)
+	m.azure.graphClient.Authorizer = authorizer
+	azuretracing.DecorateAzureAutoRestClient(&m.azure.graphClient)
+
+	m.azure.servicePrincipalClientIds = map[string]string{}
+
+	m.Logger.Infof("discovering system-assigned identities of resource types: %v", strings.Join(m.Conf.Azure.SystemAssigned, ", "))
+}
+
+// fetchAzureSystemAssignedIdentityList returns the system-assigned identities of the configured resource types
+// the identities are returned as Azure MSI with the resource id, name, tags and type of the resource
+func (m *MsiOperator) fetchAzureSystemAssignedIdentityList(ctx context.Context, subscription *subscriptions.Subscription) (ret []*msi.Identity, err error) {
+	client := resources.NewClientWithBaseURI(m.azure.environment.ResourceManagerEndpoint, *subscription.SubscriptionID)
+	m.decorateAzureClient(&client.Client)
+
+	for _, resourceType := range m.Conf.Azure.SystemAssigned {
+		list, azureErr := client.ListComplete(ctx, fmt.Sprintf("resourceType eq '%s'", resourceType), "", nil)
+		if azureErr != nil {
+			err = fmt.Errorf("failed to list Azure resources of type \"%s\": %w", resourceType, azureErr)
+			return
+		}
+
+		for list.NotDone() {
+			resource := list.Value()
+			identity, identityErr := m.systemAssignedIdentity(ctx, resource)
+			if identityErr != nil {
+				m.Logger.Warnf("skipping system-assigned identity of Azure resource %v: %v", to.String(resource.ID), identityErr)
+				m.history.addError("%s: skipping system-assigned identity: %v", strings.ToLower(to.String(resource.ID)), identityErr)
+			} else if identity != nil {
+				ret = append(ret, identity)
+			}
+
+			if list.NextWithContext(ctx) != nil {
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// systemAssignedIdentity returns the system-assigned identity of the Azure resource (nil if the resource has none)
+func (m *MsiOperator) systemAssignedIdentity(ctx context.Context, resource resources.GenericResourceExpanded) (*msi.Identity, error) {
+	if resource.Identity == nil || resource.Identity.PrincipalID == nil || !strings.Contains(string(resource.Identity.Type), string(resources.ResourceIdentityTypeSystemAssigned)) {
+		return nil, nil
+	}
+
+	principalId, err := uuid.FromString(to.String(resource.Identity.PrincipalID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid principal id: %w", err)
+	}
+
+	tenantId, err := uuid.FromString(to.String(resource.Identity.TenantID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant id: %w", err)
+	}
+
+	clientIdVal, err := m.lookupServicePrincipalClientId(ctx, principalId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	clientId, err := uuid.FromString(clientIdVal)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client id: %w", err)
+	}
+
+	return &msi.Identity{
+		ID:       resource.ID,
+		Name:     resource.Name,
+		Location: resource.Location,
+		Tags:     resource.Tags,
+		Type:     resource.Type,
+		UserAssignedIdentityProperties: &msi.UserAssignedIdentityProperties{
+			TenantID:    &tenantId,
+			PrincipalID: &principalId,
+			ClientID:    &clientId,
+		},
+	}, nil
+}
+
+// lookupServicePrincipalClientId returns the client id (appId) of the service principal using Microsoft Graph
+// client ids don't change, so they are cached (discovery runs are serialized by the run lock)
+func (m *MsiOperator) lookupServicePrincipalClientId(ctx context.Context, principalId string) (string, error) {
+	if clientId, exists := m.azure.servicePrincipalClientIds[principalId]; exists {
+		return clientId, nil
+	}
+
+	req, err := autorest.Prepare(
+		(&http.Request{}).WithContext(ctx),
+		autorest.AsGet(),
+		autorest.WithBaseURL(m.azure.environment.MicrosoftGraphEndpoint),
+		autorest.WithPathParameters("/v1.0/servicePrincipals/{principalId}", map[string]interface{}{
+			"principalId": autorest.Encode("path", principalId),
+		}),
+		autorest.WithQueryParameters(map[string]interface{}{
+			"$select": autorest.Encode("query", "appId"),
+		}),
+		m.azure.graphClient.WithAuthorization(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := m.azure.graphClient.Send(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch service principal \"%s\": %w", principalId, err)
+	}
+
+	result := struct {
+		AppId string `json:"appId"`
+	}{}
+	err = autorest.Respond(
+		resp,
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(&result),
+		autorest.ByClosing(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch service principal \"%s\": %w", principalId, err)
+	}
+
+	m.azure.servicePrincipalClientIds[principalId] = result.AppId
+	return result.AppId, nil
+}
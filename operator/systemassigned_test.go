@@ -0,0 +1,180 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/resources/mgmt/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	testSystemAssignedPrincipalId = "00000000-0000-0000-0000-00000000000a"
+	testSystemAssignedTenantId    = "00000000-0000-0000-0000-00000000000b"
+	testSystemAssignedClientId    = "00000000-0000-0000-0000-00000000000c"
+)
+
+// newSystemAssignedTestOperator returns an operator using a fake Microsoft Graph server and the number of requests to it
+func newSystemAssignedTestOperator(t *testing.T) (*MsiOperator, *int) {
+	t.Helper()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v1.0/servicePrincipals/"+testSystemAssignedPrincipalId || r.URL.Query().Get("$select") != "appId" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"appId": "` + testSystemAssignedClientId + `"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	m := newTestOperator()
+	m.azure.environment.MicrosoftGraphEndpoint = server.URL
+	m.azure.graphClient = autorest.NewClientWithUserAgent(m.UserAgent)
+	m.azure.servicePrincipalClientIds = map[string]string{}
+	return m, &requests
+}
+
+func testSystemAssignedResource(principalId string) resources.GenericResourceExpanded {
+	return resources.GenericResourceExpanded{
+		ID:   to.StringPtr("/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/nodepool"),
+		Name: to.StringPtr("nodepool"),
+		Type: to.StringPtr("Microsoft.Compute/virtualMachineScaleSets"),
+		Identity: &resources.Identity{
+			Type:        resources.ResourceIdentityTypeSystemAssignedUserAssigned,
+			PrincipalID: to.StringPtr(principalId),
+			TenantID:    to.StringPtr(testSystemAssignedTenantId),
+		},
+	}
+}
+
+func TestSystemAssignedIdentity(t *testing.T) {
+	m, requests := newSystemAssignedTestOperator(t)
+
+	identity, err := m.systemAssignedIdentity(context.Background(), testSystemAssignedResource(testSystemAssignedPrincipalId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity == nil || identity.UserAssignedIdentityProperties == nil {
+		t.Fatalf("expected identity, got %+v", identity)
+	}
+	if clientId := identity.ClientID.String(); clientId != testSystemAssignedClientId {
+		t.Errorf("expected client id %s, got %s", testSystemAssignedClientId, clientId)
+	}
+	if principalId := identity.PrincipalID.String(); principalId != testSystemAssignedPrincipalId {
+		t.Errorf("expected principal id %s, got %s", testSystemAssignedPrincipalId, principalId)
+	}
+	if to.String(identity.Type) != "Microsoft.Compute/virtualMachineScaleSets" {
+		t.Errorf("expected resource type of the resource, got %s", to.String(identity.Type))
+	}
+
+	// client ids are cached
+	if _, err := m.systemAssignedIdentity(context.Background(), testSystemAssignedResource(testSystemAssignedPrincipalId)); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 1 {
+		t.Errorf("expected 1 Microsoft Graph request, got %d", *requests)
+	}
+
+	// unknown service principal
+	if _, err := m.systemAssignedIdentity(context.Background(), testSystemAssignedResource("00000000-0000-0000-0000-00000000000d")); err == nil {
+		t.Errorf("expected error for unknown service principal")
+	}
+
+	// invalid principal id, no lookup
+	if _, err := m.systemAssignedIdentity(context.Background(), testSystemAssignedResource("invalid")); err == nil {
+		t.Errorf("expected error for invalid principal id")
+	}
+
+	// resources without system-assigned identity
+	resource := testSystemAssignedResource(testSystemAssignedPrincipalId)
+	resource.Identity.Type = resources.ResourceIdentityTypeUserAssigned
+	for _, resource := range []resources.GenericResourceExpanded{{ID: resource.ID}, resource} {
+		if identity, err := m.systemAssignedIdentity(context.Background(), resource); identity != nil || err != nil {
+			t.Errorf("expected no identity, got %+v %v", identity, err)
+		}
+	}
+	if *requests != 2 {
+		t.Errorf("expected 2 Microsoft Graph requests, got %d", *requests)
+	}
+}
+
+func TestGenerateMsiKubernetesResourceInfoSystemAssigned(t *testing.T) {
+	m, _ := newSystemAssignedTestOperator(t)
+	emptyTemplate := template.Must(template.New("").Parse(""))
+	m.msi.resourceNameTemplate = emptyTemplate
+	m.msi.namespaceTemplate = emptyTemplate
+	m.msi.bindingSelectorTemplate = emptyTemplate
+	m.msi.clusterTemplate = emptyTemplate
+
+	identity, err := m.systemAssignedIdentity(context.Background(), testSystemAssignedResource(testSystemAssignedPrincipalId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msiInfo, err := m.generateMsiKubernetesResourceInfo(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msiInfo.SystemAssigned || !msiInfo.SyncDisabled {
+		t.Errorf("expected system-assigned identity with sync disabled, got %+v", msiInfo)
+	}
+
+	m.Conf.Azure.SystemAssignedSync = true
+	msiInfo, err = m.generateMsiKubernetesResourceInfo(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msiInfo.SystemAssigned || msiInfo.SyncDisabled {
+		t.Errorf("expected system-assigned identity with sync enabled, got %+v", msiInfo)
+	}
+
+	identity.Type = to.StringPtr(AzureMsiResourceTypeUserAssigned)
+	msiInfo, err = m.generateMsiKubernetesResourceInfo(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msiInfo.SystemAssigned || msiInfo.SyncDisabled {
+		t.Errorf("expected user-assigned identity, got %+v", msiInfo)
+	}
+}
+
+func TestSystemAssignedIdentityNotSynced(t *testing.T) {
+	m := newUpsertTestOperator()
+	msiInfo := testSyncMsiResourceInfo("nodepool", "team-a")
+	msiInfo.SystemAssigned = true
+	msiInfo.SyncDisabled = true
+	m.serviceDiscovery.msi.Update(msiInfo)
+
+	if reason := msiSkipReason(msiInfo, m.kubernetes.cluster.name); reason != MsiSkipReasonSystemAssigned {
+		t.Errorf("expected skip reason %s, got %q", MsiSkipReasonSystemAssigned, reason)
+	}
+
+	run := testUpsertCluster(t, m)
+	if run.Created != 30 {
+		t.Errorf("expected only user-assigned identities to be created (30), got %+v", run)
+	}
+	testAssertAzureIdentities(t, m, 30)
+}
+
+func TestSystemAssignedIdentitySynced(t *testing.T) {
+	m := newUpsertTestOperator()
+	msiInfo := testSyncMsiResourceInfo("nodepool", "team-a")
+	msiInfo.SystemAssigned = true
+	m.serviceDiscovery.msi.Update(msiInfo)
+
+	if reason := msiSkipReason(msiInfo, m.kubernetes.cluster.name); reason != "" {
+		t.Errorf("expected no skip reason, got %q", reason)
+	}
+
+	run := testUpsertCluster(t, m)
+	if run.Created != 31 {
+		t.Errorf("expected system-assigned identity to be created (31), got %+v", run)
+	}
+	testAssertAzureIdentities(t, m, 31)
+}
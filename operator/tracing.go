@@ -0,0 +1,105 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/webdevops/azure-msi-operator"
+
+	// span attributes
+	TraceAttrSyncTrigger         = attribute.Key("sync.trigger")
+	TraceAttrSnapshotVersion     = attribute.Key("sync.snapshot_version")
+	TraceAttrAzureSubscriptionId = attribute.Key("azure.subscription_id")
+	TraceAttrAzureResourceId     = attribute.Key("azure.resource_id")
+	TraceAttrAzureMsiCount       = attribute.Key("azure.msi_count")
+	TraceAttrK8sCluster          = attribute.Key("k8s.cluster.name")
+	TraceAttrK8sNamespace        = attribute.Key("k8s.namespace.name")
+	TraceAttrK8sResourceName     = attribute.Key("k8s.resource.name")
+)
+
+// initTracing sets up the tracer, spans are only exported if tracing is enabled
+func (m *MsiOperator) initTracing() {
+	if !m.Conf.Tracing.Enabled {
+		m.tracing.tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+		return
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(m.Conf.Tracing.Endpoint),
+	}
+	if m.Conf.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(m.ctx, opts...)
+	if err != nil {
+		m.Logger.Panic(err)
+	}
+
+	m.tracing.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(m.Conf.Tracing.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(m.Conf.Tracing.ServiceName),
+		)),
+	)
+	m.tracing.tracer = m.tracing.provider.Tracer(tracerName)
+
+	m.Logger.Infof("exporting OpenTelemetry traces to %v", m.Conf.Tracing.Endpoint)
+}
+
+// shutdownTracing flushes pending spans
+func (m *MsiOperator) shutdownTracing(ctx context.Context) {
+	if m.tracing.provider == nil {
+		return
+	}
+
+	if err := m.tracing.provider.Shutdown(ctx); err != nil {
+		m.Logger.Warnf("failed to flush OpenTelemetry traces: %v", err)
+	}
+}
+
+// wrapKubernetesTransport traces Kubernetes API calls which are part of a traced operation
+// (calls without parent span, eg. watches and leader election, are not traced)
+func (m *MsiOperator) wrapKubernetesTransport(rt http.RoundTripper) http.RoundTripper {
+	if m.tracing.provider == nil {
+		return rt
+	}
+
+	return otelhttp.NewTransport(
+		rt,
+		otelhttp.WithTracerProvider(m.tracing.provider),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanContextFromContext(r.Context()).IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return "kubernetes " + r.Method
+		}),
+	)
+}
+
+// startSpan starts a span as child of the span in ctx
+func (m *MsiOperator) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return m.tracing.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error (if any) and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
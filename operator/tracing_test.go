@@ -0,0 +1,65 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingDisabled(t *testing.T) {
+	m := newTestOperator()
+
+	// no provider, spans are not recorded and Kubernetes calls are not wrapped
+	if m.tracing.provider != nil {
+		t.Fatalf("expected no tracer provider if tracing is disabled")
+	}
+	ctx, span := m.startSpan(context.Background(), "run", TraceAttrSyncTrigger.String(SyncScheduleReconcile))
+	if span.IsRecording() || span.SpanContext().IsValid() {
+		t.Errorf("expected noop span")
+	}
+	endSpan(span, errors.New("failed"))
+	if _, child := m.startSpan(ctx, "child"); child.SpanContext().IsValid() {
+		t.Errorf("expected noop child span")
+	}
+
+	if rt := m.wrapKubernetesTransport(http.DefaultTransport); rt != http.DefaultTransport {
+		t.Errorf("expected unwrapped Kubernetes transport")
+	}
+	m.shutdownTracing(context.Background())
+}
+
+func TestEndSpanRecordsError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	m := newTestOperator()
+	m.tracing.provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	m.tracing.tracer = m.tracing.provider.Tracer(tracerName)
+
+	ctx, span := m.startSpan(context.Background(), "run", TraceAttrSyncTrigger.String(SyncScheduleReconcile))
+	_, child := m.startSpan(ctx, "upsert")
+	endSpan(child, nil)
+	endSpan(span, errors.New("failed to upsert namespace"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 ended spans, got %d", len(spans))
+	}
+
+	if spans[0].Name() != "upsert" || spans[0].Status().Code != codes.Unset || len(spans[0].Events()) != 0 {
+		t.Errorf("expected successful span without error, got %v %+v", spans[0].Name(), spans[0].Status())
+	}
+	if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Errorf("expected child span of run span")
+	}
+
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "failed to upsert namespace" {
+		t.Errorf("expected error status, got %+v", spans[1].Status())
+	}
+	if events := spans[1].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("expected recorded error event, got %+v", events)
+	}
+}
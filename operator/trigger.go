@@ -0,0 +1,144 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ApiPathSync = "/api/v1/sync"
+
+	SyncTriggerQueued    = "queued"
+	SyncTriggerCoalesced = "coalesced"
+)
+
+type (
+	syncTrigger struct {
+		lock sync.Mutex
+
+		// pending namespaces, empty namespace is a full sync
+		pending map[string]bool
+		notify  chan struct{}
+	}
+
+	ApiSyncResponse struct {
+		Status    string `json:"status"`
+		Namespace string `json:"namespace,omitempty"`
+	}
+)
+
+var (
+	ErrSyncNotLeader           = errors.New("operator is not leader")
+	ErrSyncNamespaceNotAllowed = errors.New("namespace is not maintained by operator")
+)
+
+func newSyncTrigger() *syncTrigger {
+	return &syncTrigger{
+		pending: map[string]bool{},
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// TriggerSync queues a full (empty namespace) or namespace scoped sync
+// returns SyncTriggerCoalesced if the sync is already covered by a pending sync
+//
+// only pending (not yet started) syncs are coalesced, a sync of the namespace which is already running
+// might have processed the namespace before the change which triggered the request, so another run is queued
+func (m *MsiOperator) TriggerSync(namespace string) (string, error) {
+	namespace = strings.ToLower(namespace)
+
+	if !m.health.isLeader() {
+		return "", ErrSyncNotLeader
+	}
+
+	if namespace != "" && !m.kubernetes.namespaceMatcher.IsAllowed(namespace) {
+		return "", ErrSyncNamespaceNotAllowed
+	}
+
+	t := m.syncTrigger
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.pending[""] || t.pending[namespace] {
+		return SyncTriggerCoalesced, nil
+	}
+	t.pending[namespace] = true
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+		// worker already notified
+	}
+
+	return SyncTriggerQueued, nil
+}
+
+func (m *MsiOperator) startSyncTriggerWorker(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.syncTrigger.notify:
+			}
+
+			t := m.syncTrigger
+			t.lock.Lock()
+			namespaces := []string{}
+			if !t.pending[""] {
+				for namespace := range t.pending {
+					namespaces = append(namespaces, namespace)
+				}
+				sort.Strings(namespaces)
+			}
+			t.pending = map[string]bool{}
+			t.lock.Unlock()
+
+			if len(namespaces) == 0 {
+				m.Logger.Info("starting manually triggered sync for cluster")
+			} else {
+				m.Logger.Infof("starting manually triggered sync for namespaces %v", strings.Join(namespaces, ", "))
+			}
+
+			err := m.run(ctx, SyncRunTriggerManual, func(ctx context.Context) error {
+				return m.sync(ctx, true, namespaces...)
+			})
+			if err != nil {
+				m.Logger.Warnf("manually triggered sync failed: %v", err)
+			}
+		}
+	}()
+}
+
+// HandleApiSync triggers a sync (POST /api/v1/sync?namespace=x), requires bearer token
+func (m *MsiOperator) HandleApiSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkBearerToken(r, m.Conf.Server.SyncToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	status, err := m.TriggerSync(namespace)
+	switch {
+	case errors.Is(err, ErrSyncNotLeader):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.writeApiResponseWithStatus(w, http.StatusAccepted, ApiSyncResponse{
+		Status:    status,
+		Namespace: namespace,
+	})
+}
@@ -0,0 +1,70 @@
+package operator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleApiSync(t *testing.T) {
+	m := newTestOperator()
+	m.Conf.Server.SyncToken = "secret"
+	m.syncTrigger = newSyncTrigger()
+	m.kubernetes.namespaceMatcher, _ = NewNamespaceMatcher(nil, nil)
+	m.health.setLeader(true)
+
+	request := func(token, namespace string) (*httptest.ResponseRecorder, ApiSyncResponse) {
+		r := httptest.NewRequest(http.MethodPost, ApiPathSync+"?namespace="+namespace, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		m.HandleApiSync(w, r)
+
+		response := ApiSyncResponse{}
+		if w.Code == http.StatusAccepted {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, response
+	}
+
+	if w, _ := request("wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for invalid token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w, response := request("secret", "team-a")
+	if w.Code != http.StatusAccepted || response.Status != SyncTriggerQueued {
+		t.Errorf("expected queued sync, got %d %+v", w.Code, response)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected json response, got %q", contentType)
+	}
+
+	// pending sync of namespace and full sync cover the namespace
+	if _, response := request("secret", "team-a"); response.Status != SyncTriggerCoalesced {
+		t.Errorf("expected coalesced sync, got %+v", response)
+	}
+	if _, response := request("secret", ""); response.Status != SyncTriggerQueued {
+		t.Errorf("expected queued full sync, got %+v", response)
+	}
+	if _, response := request("secret", "team-b"); response.Status != SyncTriggerCoalesced {
+		t.Errorf("expected sync coalesced into full sync, got %+v", response)
+	}
+
+	m.health.setLeader(false)
+	if w, _ := request("secret", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d if not leader, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestWriteApiResponseWithStatus(t *testing.T) {
+	m := newTestOperator()
+
+	// marshal error must not write the status twice
+	w := httptest.NewRecorder()
+	m.writeApiResponseWithStatus(w, http.StatusAccepted, map[string]interface{}{"invalid": make(chan int)})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// max size of AdmissionReview request body
+	webhookMaxRequestSize = 5 * 1024 * 1024
+
+	// timeout for Kubernetes lookups of admission handlers (below timeoutSeconds of webhook configurations)
+	webhookLookupTimeout = 3 * time.Second
+)
+
+type (
+	admissionHandlerFunc func(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+)
+
+// serveAdmissionReview decodes the AdmissionReview request, passes it to the handler and writes the AdmissionReview response
+func (m *MsiOperator) serveAdmissionReview(w http.ResponseWriter, r *http.Request, handler admissionHandlerFunc) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxRequestSize))
+	if err != nil {
+		m.Logger.Errorf("failed to read AdmissionReview: %v", err)
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		m.Logger.Errorf("failed to decode AdmissionReview: %v", err)
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), webhookLookupTimeout)
+	defer cancel()
+
+	response := handler(ctx, review.Request)
+	response.UID = review.Request.UID
+
+	review.Request = nil
+	review.Response = response
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		m.Logger.Error(err)
+	}
+}
+
+// admissionAllow returns an allowing AdmissionResponse
+func admissionAllow() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+	}
+}
+
+// admissionDeny returns a denying AdmissionResponse with reason
+func admissionDeny(message string, args ...interface{}) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+			Message: fmt.Sprintf(message, args...),
+		},
+	}
+}
+
+// isAdmissionExempt checks if the admission request is exempt from validation (user, group or namespace)
+func (m *MsiOperator) isAdmissionExempt(request *admissionv1.AdmissionRequest) bool {
+	for _, user := range m.Conf.Webhook.ExemptUsers {
+		if request.UserInfo.Username == user {
+			return true
+		}
+	}
+
+	for _, group := range m.Conf.Webhook.ExemptGroups {
+		for _, userGroup := range request.UserInfo.Groups {
+			if userGroup == group {
+				return true
+			}
+		}
+	}
+
+	for _, pattern := range m.webhook.exemptNamespaces {
+		if pattern.match(request.Namespace) {
+			return true
+		}
+	}
+
+	return false
+}
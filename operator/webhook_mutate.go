@@ -0,0 +1,172 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	K8sLabelAadPodIdBinding = "aadpodidbinding"
+)
+
+type (
+	jsonPatchOperation struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value,omitempty"`
+	}
+)
+
+// HandleMutatingWebhook handles AdmissionReview requests for Pods and injects the Azure MSI referenced by labels
+func (m *MsiOperator) HandleMutatingWebhook(w http.ResponseWriter, r *http.Request) {
+	m.serveAdmissionReview(w, r, m.mutateAdmission)
+}
+
+func (m *MsiOperator) mutateAdmission(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Kind.Group != "" || request.Kind.Kind != "Pod" || request.Operation != admissionv1.Create {
+		return admissionAllow()
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		return admissionDeny("unable to decode Pod: %v", err)
+	}
+
+	labels := pod.GetLabels()
+	msiName := strings.ToLower(labels[m.labelName("name")])
+	if msiName == "" {
+		// no MSI reference
+		return admissionAllow()
+	}
+	msiResourceGroup := strings.ToLower(labels[m.labelName("resourcegroup")])
+	msiSubscription := strings.ToLower(labels[m.labelName("subscription")])
+
+	// pod name might be generated
+	podName := pod.GetName()
+	if podName == "" {
+		podName = pod.GetGenerateName()
+	}
+
+	contextLogger := m.Logger.With(
+		zap.String("k8sNamespace", request.Namespace),
+		zap.String("k8sResource", podName),
+		zap.String("msi", msiName),
+	)
+
+	if m.serviceDiscovery.msi.Version() == 0 {
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSIs not discovered yet", request.Namespace, podName)
+		return admissionDeny("Azure MSIs not discovered yet, please retry")
+	}
+
+	msiList := m.serviceDiscovery.msi.Find(func(row MsiResourceInfo) bool {
+		if row.SyncDisabled || to.String(row.AzureResourceName) != msiName {
+			return false
+		}
+		if msiResourceGroup != "" && to.String(row.AzureResourceGroup) != msiResourceGroup {
+			return false
+		}
+		if msiSubscription != "" && to.String(row.AzureSubscriptionId) != msiSubscription {
+			return false
+		}
+		return true
+	})
+
+	switch {
+	case len(msiList) == 0:
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSI \"%s\" not found", request.Namespace, podName, msiName)
+		return admissionDeny("Azure MSI \"%s\" (resourcegroup: \"%s\", subscription: \"%s\") not found", msiName, msiResourceGroup, msiSubscription)
+	case len(msiList) > 1:
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSI \"%s\" is ambiguous", request.Namespace, podName, msiName)
+		return admissionDeny(
+			"Azure MSI \"%s\" is ambiguous (found %d), please specify labels \"%s\" and \"%s\"",
+			msiName,
+			len(msiList),
+			m.labelName("resourcegroup"),
+			m.labelName("subscription"),
+		)
+	}
+	msiInfo := msiList[0]
+
+	// same checks as sync
+	if !msiInfo.TargetsCluster(m.kubernetes.cluster.name) {
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSI \"%s\" is excluded from cluster", request.Namespace, podName, to.String(msiInfo.AzureResourceId))
+		return admissionDeny("Azure MSI \"%s\" is not synced to cluster \"%s\"", to.String(msiInfo.AzureResourceId), m.kubernetes.cluster.name)
+	}
+
+	if !contains(msiInfo.KubernetesNamespace, request.Namespace) {
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSI \"%s\" is not allowed in namespace", request.Namespace, podName, to.String(msiInfo.AzureResourceId))
+		return admissionDeny("Azure MSI \"%s\" is not allowed in namespace \"%s\"", to.String(msiInfo.AzureResourceId), request.Namespace)
+	}
+
+	decision, err := m.evaluateMsiPolicy(ctx, m.kubernetes.cluster, msiInfo, request.Namespace, newUpsertCache(nil))
+	if err != nil {
+		contextLogger.Errorf("denied Pod \"%s/%s\": %v", request.Namespace, podName, err)
+		return admissionDeny("unable to check Azure MSI \"%s\": %v", to.String(msiInfo.AzureResourceId), err)
+	} else if !decision.Allowed {
+		contextLogger.Infof("denied Pod \"%s/%s\": Azure MSI \"%s\" denied by policy (rule \"%s\"): %s", request.Namespace, podName, to.String(msiInfo.AzureResourceId), decision.Rule, decision.Reason)
+		return admissionDeny("Azure MSI \"%s\" is denied by policy in namespace \"%s\" (rule \"%s\"): %s", to.String(msiInfo.AzureResourceId), request.Namespace, decision.Rule, decision.Reason)
+	}
+
+	// Pod has labels (MSI reference), no need to add /metadata/labels
+	if msiInfo.KubernetesBindingSelector == nil {
+		return admissionDeny("no AzureIdentityBinding selector found for Azure MSI \"%s\"", to.String(msiInfo.AzureResourceId))
+	}
+	patch := []jsonPatchOperation{
+		{Op: "add", Path: "/metadata/labels/" + jsonPatchEscape(K8sLabelAadPodIdBinding), Value: *msiInfo.KubernetesBindingSelector},
+	}
+
+	// without generation the AzureIdentityBinding is maintained by users, the Pod would start without identity if missing
+	var warnings []string
+	if !m.Conf.AzureIdentity.Binding.Generate {
+		if err := m.checkAzureIdentityBindingSelector(ctx, request.Namespace, *msiInfo.KubernetesBindingSelector); err != nil {
+			contextLogger.Warnf("Pod \"%s/%s\": %v", request.Namespace, podName, err)
+			warnings = append(warnings, err.Error())
+		}
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return admissionDeny("unable to generate patch: %v", err)
+	}
+
+	contextLogger.Infof("injecting Azure MSI \"%s\" into Pod \"%s/%s\"", to.String(msiInfo.AzureResourceId), request.Namespace, podName)
+	patchType := admissionv1.PatchTypeJSONPatch
+	response := admissionAllow()
+	response.Patch = patchBytes
+	response.PatchType = &patchType
+	response.Warnings = warnings
+	return response
+}
+
+// checkAzureIdentityBindingSelector returns an error if no AzureIdentityBinding with the selector exists in the namespace
+func (m *MsiOperator) checkAzureIdentityBindingSelector(ctx context.Context, namespace, selector string) error {
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Resource: K8sSchemeAzureIdentityBindingResourcePlural}
+	list, err := m.kubernetes.client.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to check AzureIdentityBinding with selector \"%s\": %w", selector, err)
+	}
+
+	for _, azureIdentityBinding := range list.Items {
+		if val, _, _ := unstructured.NestedString(azureIdentityBinding.Object, "spec", "selector"); val == selector {
+			return nil
+		}
+	}
+	return fmt.Errorf("no AzureIdentityBinding with selector \"%s\" found in namespace \"%s\", Pod will start without Azure MSI", selector, namespace)
+}
+
+// jsonPatchEscape escapes a key for usage in a json patch path (RFC 6901)
+func jsonPatchEscape(val string) string {
+	val = strings.ReplaceAll(val, "~", "~0")
+	return strings.ReplaceAll(val, "/", "~1")
+}
@@ -0,0 +1,178 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testPodAdmissionRequest(t *testing.T, namespace string, labels map[string]string) *admissionv1.AdmissionRequest {
+	t.Helper()
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "app-",
+			Namespace:    namespace,
+			Labels:       labels,
+		},
+	}
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+		Namespace: namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func testMutateMsiResourceInfo(subscription, resourceGroup, name, selector string, namespaces ...string) MsiResourceInfo {
+	msiInfo := testMsiResourceInfo(name, map[string]string{}, namespaces...)
+	msiInfo.AzureResourceId = to.StringPtr("/subscriptions/" + subscription + "/resourcegroups/" + resourceGroup + "/providers/microsoft.managedidentity/userassignedidentities/" + name)
+	msiInfo.AzureSubscriptionId = to.StringPtr(subscription)
+	msiInfo.AzureResourceGroup = to.StringPtr(resourceGroup)
+	if selector != "" {
+		msiInfo.KubernetesBindingSelector = to.StringPtr(selector)
+	}
+	return msiInfo
+}
+
+func TestMutateAdmission(t *testing.T) {
+	m := newTestOperator()
+
+	// not discovered yet
+	response := m.mutateAdmission(context.Background(), testPodAdmissionRequest(t, "team-a", map[string]string{"msi.azure.k8s.io/name": "foo"}))
+	if response.Allowed {
+		t.Fatalf("expected Pod to be denied before first discovery")
+	}
+
+	m.serviceDiscovery.msi.Add(testMutateMsiResourceInfo("sub1", "rg1", "foo", "foo-selector", "team-a"))
+	m.serviceDiscovery.msi.Add(testMutateMsiResourceInfo("sub1", "rg1", "dup", "dup-rg1", "team-a"))
+	m.serviceDiscovery.msi.Add(testMutateMsiResourceInfo("sub1", "rg2", "dup", "dup-rg2", "team-a"))
+	m.serviceDiscovery.msi.Add(testMutateMsiResourceInfo("sub1", "rg1", "noselector", "", "team-a"))
+	m.serviceDiscovery.msi.Commit()
+
+	tests := []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		allowed   bool
+		patch     string
+	}{
+		{"no MSI label", "team-a", map[string]string{"app": "foo"}, true, ""},
+		{"injected", "team-a", map[string]string{"msi.azure.k8s.io/name": "foo"}, true, `[{"op":"add","path":"/metadata/labels/aadpodidbinding","value":"foo-selector"}]`},
+		{"injected with resourcegroup", "team-a", map[string]string{"msi.azure.k8s.io/name": "dup", "msi.azure.k8s.io/resourcegroup": "rg2"}, true, `[{"op":"add","path":"/metadata/labels/aadpodidbinding","value":"dup-rg2"}]`},
+		{"unknown MSI", "team-a", map[string]string{"msi.azure.k8s.io/name": "bar"}, false, ""},
+		{"ambiguous MSI", "team-a", map[string]string{"msi.azure.k8s.io/name": "dup"}, false, ""},
+		{"wrong subscription", "team-a", map[string]string{"msi.azure.k8s.io/name": "foo", "msi.azure.k8s.io/subscription": "sub2"}, false, ""},
+		{"namespace not allowed", "team-b", map[string]string{"msi.azure.k8s.io/name": "foo"}, false, ""},
+		{"no selector", "team-a", map[string]string{"msi.azure.k8s.io/name": "noselector"}, false, ""},
+	}
+
+	for _, test := range tests {
+		response := m.mutateAdmission(context.Background(), testPodAdmissionRequest(t, test.namespace, test.labels))
+		if response.Allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v (%+v)", test.name, test.allowed, response.Allowed, response.Result)
+			continue
+		}
+		if string(response.Patch) != test.patch {
+			t.Errorf("%s: expected patch %s, got %s", test.name, test.patch, string(response.Patch))
+		}
+	}
+
+	// Pods without labels are not mutated
+	request := testPodAdmissionRequest(t, "team-a", nil)
+	if response := m.mutateAdmission(context.Background(), request); !response.Allowed || response.Patch != nil {
+		t.Errorf("expected Pod without labels to be allowed without patch")
+	}
+
+	// only Pod creation is mutated
+	request = testPodAdmissionRequest(t, "team-b", map[string]string{"msi.azure.k8s.io/name": "foo"})
+	request.Operation = admissionv1.Update
+	if response := m.mutateAdmission(context.Background(), request); !response.Allowed || response.Patch != nil {
+		t.Errorf("expected Pod update to be allowed without patch")
+	}
+
+	// invalid Pod
+	request = testPodAdmissionRequest(t, "team-a", nil)
+	request.Object.Raw = []byte("{invalid")
+	if response := m.mutateAdmission(context.Background(), request); response.Allowed {
+		t.Errorf("expected invalid Pod to be denied")
+	}
+}
+
+func TestMutateAdmissionClusterAndPolicy(t *testing.T) {
+	m := newTestOperator()
+	policy, err := ParseMsiPolicy([]byte("rules:\n  - name: team-a\n    namespaces: [\"team-a\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.policy = policy
+
+	foo := testSyncMsiResourceInfo("foo", "team-a", "team-b")
+	foo.KubernetesBindingSelector = to.StringPtr("foo")
+	excluded := testSyncMsiResourceInfo("excluded", "team-a")
+	excluded.KubernetesBindingSelector = to.StringPtr("excluded")
+	excluded.KubernetesClusters = []string{"prod-*"}
+	m.serviceDiscovery.msi.Add(foo)
+	m.serviceDiscovery.msi.Add(excluded)
+	m.serviceDiscovery.msi.Commit()
+
+	// same checks as sync
+	tests := []struct {
+		name      string
+		namespace string
+		msi       string
+		allowed   bool
+	}{
+		{"allowed by policy", "team-a", "foo", true},
+		{"denied by policy", "team-b", "foo", false},
+		{"excluded from cluster", "team-a", "excluded", false},
+	}
+
+	for _, test := range tests {
+		response := m.mutateAdmission(context.Background(), testPodAdmissionRequest(t, test.namespace, map[string]string{"msi.azure.k8s.io/name": test.msi}))
+		if response.Allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v (%+v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+	}
+}
+
+func TestMutateAdmissionBindingWarning(t *testing.T) {
+	binding := testAzureIdentityBinding("team-a", "foo", nil, nil)
+	binding.Object["spec"] = map[string]interface{}{"selector": "foo-selector"}
+	m := newTestOperator(binding)
+	m.serviceDiscovery.msi.Add(testMutateMsiResourceInfo("sub1", "rg1", "foo", "foo-selector", "team-a", "team-b"))
+	m.serviceDiscovery.msi.Commit()
+
+	mutate := func(namespace string) *admissionv1.AdmissionResponse {
+		t.Helper()
+		response := m.mutateAdmission(context.Background(), testPodAdmissionRequest(t, namespace, map[string]string{"msi.azure.k8s.io/name": "foo"}))
+		if !response.Allowed || response.Patch == nil {
+			t.Fatalf("expected Pod to be mutated, got %+v", response.Result)
+		}
+		return response
+	}
+
+	// AzureIdentityBindings are maintained by users without generation
+	if response := mutate("team-a"); len(response.Warnings) != 0 {
+		t.Errorf("expected no warning with existing AzureIdentityBinding, got %v", response.Warnings)
+	}
+	if response := mutate("team-b"); len(response.Warnings) != 1 {
+		t.Errorf("expected warning for missing AzureIdentityBinding, got %v", response.Warnings)
+	}
+
+	m.Conf.AzureIdentity.Binding.Generate = true
+	if response := mutate("team-b"); len(response.Warnings) != 0 {
+		t.Errorf("expected no warning with AzureIdentityBinding generation, got %v", response.Warnings)
+	}
+}
@@ -0,0 +1,117 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// HandleValidatingWebhook handles AdmissionReview requests for AzureIdentity and AzureIdentityBinding resources
+func (m *MsiOperator) HandleValidatingWebhook(w http.ResponseWriter, r *http.Request) {
+	m.serveAdmissionReview(w, r, m.validateAdmission)
+}
+
+func (m *MsiOperator) validateAdmission(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return admissionAllow()
+	}
+
+	// operator itself
+	if request.UserInfo.Username == m.Conf.Webhook.ServiceAccount {
+		return admissionAllow()
+	}
+
+	if m.isAdmissionExempt(request) {
+		return admissionAllow()
+	}
+
+	if request.Kind.Group != K8sSchemeAzureIdentityGroup {
+		return admissionAllow()
+	}
+
+	contextLogger := m.Logger.With(
+		zap.String("k8sNamespace", request.Namespace),
+		zap.String("k8sResource", request.Name),
+		zap.String("user", request.UserInfo.Username),
+	)
+
+	switch request.Kind.Kind {
+	case K8sSchemeAzureIdentityResourceSingular:
+		contextLogger.Infof("denied %s of AzureIdentity \"%s/%s\"", request.Operation, request.Namespace, request.Name)
+		return admissionDeny(
+			"AzureIdentity resources are managed by azure-msi-operator, user \"%s\" is not allowed to %s AzureIdentity \"%s/%s\"",
+			request.UserInfo.Username,
+			request.Operation,
+			request.Namespace,
+			request.Name,
+		)
+	case K8sSchemeAzureIdentityBindingResourceSingular:
+		response := m.validateAzureIdentityBinding(ctx, request)
+		if !response.Allowed {
+			contextLogger.Infof("denied %s of AzureIdentityBinding \"%s/%s\": %s", request.Operation, request.Namespace, request.Name, response.Result.Message)
+		}
+		return response
+	}
+
+	return admissionAllow()
+}
+
+// validateAzureIdentityBinding ensures the referenced AzureIdentity is managed by the operator
+// and matches the MSI labels of the AzureIdentityBinding (if set)
+func (m *MsiOperator) validateAzureIdentityBinding(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	azureIdentityBinding := &unstructured.Unstructured{}
+	if err := azureIdentityBinding.UnmarshalJSON(request.Object.Raw); err != nil {
+		return admissionDeny("unable to decode AzureIdentityBinding: %v", err)
+	}
+
+	azureIdentityName, _, _ := unstructured.NestedString(azureIdentityBinding.Object, "spec", "azureIdentity")
+	if azureIdentityName == "" {
+		return admissionAllow()
+	}
+
+	gvr := schema.GroupVersionResource{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Resource: K8sSchemeAzureIdentityResourcePlural}
+	azureIdentity, err := m.kubernetes.client.Resource(gvr).Namespace(request.Namespace).Get(ctx, azureIdentityName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// AzureIdentity might be created later by the operator
+			return admissionAllow()
+		}
+		return admissionDeny("unable to verify AzureIdentity \"%s/%s\": %v", request.Namespace, azureIdentityName, err)
+	}
+
+	if azureIdentity.GetLabels()[K8sLabelManagedBy] != K8sManagedByValue {
+		return admissionDeny(
+			"AzureIdentity \"%s/%s\" is not managed by azure-msi-operator and cannot be referenced by AzureIdentityBinding \"%s/%s\"",
+			request.Namespace,
+			azureIdentityName,
+			request.Namespace,
+			azureIdentityBinding.GetName(),
+		)
+	}
+
+	// ensure binding lookup labels match the referenced AzureIdentity
+	bindingLabels := azureIdentityBinding.GetLabels()
+	identityLabels := azureIdentity.GetLabels()
+	for _, name := range []string{"alias", "subscription", "resourcegroup", "name"} {
+		labelName := m.labelName(name)
+		if val, exists := bindingLabels[labelName]; exists && val != identityLabels[labelName] {
+			return admissionDeny(
+				"AzureIdentityBinding \"%s/%s\" references AzureIdentity \"%s\" of a different Azure MSI (label %s: \"%s\" != \"%s\")",
+				request.Namespace,
+				azureIdentityBinding.GetName(),
+				azureIdentityName,
+				labelName,
+				val,
+				identityLabels[labelName],
+			)
+		}
+	}
+
+	return admissionAllow()
+}
@@ -0,0 +1,121 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func testAzureIdentity(namespace, name string, labels map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": K8sSchemeAzureIdentityGroup + "/" + K8sSchemeAzureIdentityVersion,
+			"kind":       K8sSchemeAzureIdentityResourceSingular,
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+				"labels":    labels,
+			},
+			"spec": map[string]interface{}{},
+		},
+	}
+}
+
+func testAdmissionRequest(t *testing.T, kind string, operation admissionv1.Operation, user string, groups []string, obj *unstructured.Unstructured) *admissionv1.AdmissionRequest {
+	t.Helper()
+
+	raw, err := json.Marshal(obj.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: K8sSchemeAzureIdentityGroup, Version: K8sSchemeAzureIdentityVersion, Kind: kind},
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Operation: operation,
+		UserInfo:  authenticationv1.UserInfo{Username: user, Groups: groups},
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestValidateAdmission(t *testing.T) {
+	managedLabels := map[string]interface{}{
+		K8sLabelManagedBy:          K8sManagedByValue,
+		"msi.azure.k8s.io/alias":   "aaaa",
+		"msi.azure.k8s.io/name":    "foo",
+		"msi.azure.k8s.io/unknown": "xxx",
+	}
+
+	m := newTestOperator(
+		testAzureIdentity("team-a", "managed", managedLabels),
+		testAzureIdentity("team-a", "unmanaged", map[string]interface{}{}),
+	)
+	m.Conf.Webhook.ServiceAccount = "system:serviceaccount:kube-system:azure-msi-operator"
+	m.Conf.Webhook.ExemptUsers = []string{"admin"}
+	m.Conf.Webhook.ExemptGroups = []string{"system:masters"}
+	m.Conf.Webhook.ExemptNamespaces = []string{"platform-*"}
+	m.initWebhook()
+
+	binding := func(namespace, azureIdentity string, labels map[string]interface{}) *unstructured.Unstructured {
+		obj := testAzureIdentityBinding(namespace, "binding", labels, nil)
+		obj.Object["spec"] = map[string]interface{}{"azureIdentity": azureIdentity}
+		return obj
+	}
+
+	tests := []struct {
+		name      string
+		kind      string
+		operation admissionv1.Operation
+		user      string
+		groups    []string
+		obj       *unstructured.Unstructured
+		allowed   bool
+	}{
+		{"operator creates AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Create, m.Conf.Webhook.ServiceAccount, nil, testAzureIdentity("team-a", "foo", nil), true},
+		{"user creates AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Create, "user", nil, testAzureIdentity("team-a", "foo", nil), false},
+		{"user updates AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Update, "user", nil, testAzureIdentity("team-a", "managed", nil), false},
+		{"user deletes AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Delete, "user", nil, testAzureIdentity("team-a", "managed", nil), true},
+		{"exempt user creates AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Create, "admin", nil, testAzureIdentity("team-a", "foo", nil), true},
+		{"exempt group creates AzureIdentity", K8sSchemeAzureIdentityResourceSingular, admissionv1.Create, "user", []string{"system:authenticated", "system:masters"}, testAzureIdentity("team-a", "foo", nil), true},
+		{"exempt namespace", K8sSchemeAzureIdentityResourceSingular, admissionv1.Create, "user", nil, testAzureIdentity("platform-dns", "foo", nil), true},
+		{"binding to managed AzureIdentity", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "user", nil, binding("team-a", "managed", nil), true},
+		{"binding with matching labels", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "user", nil, binding("team-a", "managed", map[string]interface{}{"msi.azure.k8s.io/alias": "aaaa", "msi.azure.k8s.io/name": "foo"}), true},
+		{"binding with mismatching label", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Update, "user", nil, binding("team-a", "managed", map[string]interface{}{"msi.azure.k8s.io/alias": "bbbb"}), false},
+		{"binding to unmanaged AzureIdentity", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "user", nil, binding("team-a", "unmanaged", nil), false},
+		{"binding to missing AzureIdentity", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "user", nil, binding("team-a", "missing", nil), true},
+		{"binding without AzureIdentity", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "user", nil, binding("team-a", "", nil), true},
+		{"exempt user binding to unmanaged AzureIdentity", K8sSchemeAzureIdentityBindingResourceSingular, admissionv1.Create, "admin", nil, binding("team-a", "unmanaged", nil), true},
+	}
+
+	for _, test := range tests {
+		request := testAdmissionRequest(t, test.kind, test.operation, test.user, test.groups, test.obj)
+		response := m.validateAdmission(context.Background(), request)
+		if response.Allowed != test.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v (%+v)", test.name, test.allowed, response.Allowed, response.Result)
+		}
+		if !response.Allowed && (response.Result == nil || response.Result.Message == "") {
+			t.Errorf("%s: expected denial message", test.name)
+		}
+	}
+}
+
+func TestValidateAzureIdentityBindingInvalidObject(t *testing.T) {
+	m := newTestOperator()
+
+	request := &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: K8sSchemeAzureIdentityBindingGroup, Version: K8sSchemeAzureIdentityBindingVersion, Kind: K8sSchemeAzureIdentityBindingResourceSingular},
+		Namespace: "team-a",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte("{invalid")},
+	}
+	if response := m.validateAzureIdentityBinding(context.Background(), request); response.Allowed {
+		t.Fatalf("expected invalid AzureIdentityBinding to be denied")
+	}
+}